@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// The static pages as browsers get them: subscribe.html rendered with its
+// sample data, index.html as it is.
+func staticPages(t *testing.T) map[string]*html.Node {
+	t.Helper()
+	var subscribe bytes.Buffer
+	page, err := parseSubscribePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := page.Execute(&subscribe, subscribePageSample); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile("static/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := map[string]*html.Node{}
+	for name, src := range map[string][]byte{"subscribe.html": subscribe.Bytes(), "index.html": index} {
+		doc, err := html.Parse(bytes.NewReader(src))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		docs[name] = doc
+	}
+	return docs
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func TestStaticPagesAccessible(t *testing.T) {
+	for name, doc := range staticPages(t) {
+		ids := map[string]int{}
+		labelFor := map[string]bool{}
+		var controls []*html.Node
+		var walk func(n *html.Node, inLabel bool)
+		walk = func(n *html.Node, inLabel bool) {
+			if n.Type == html.ElementNode {
+				if id, ok := attr(n, "id"); ok {
+					ids[id]++
+				}
+				switch n.Data {
+				case "label":
+					if id, ok := attr(n, "for"); ok {
+						labelFor[id] = true
+					}
+					inLabel = true
+				case "input", "select", "textarea":
+					typ, _ := attr(n, "type")
+					switch strings.ToLower(typ) {
+					case "hidden", "submit", "button", "reset", "image":
+					default:
+						if !inLabel {
+							controls = append(controls, n)
+						}
+					}
+				}
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c, inLabel)
+			}
+		}
+		walk(doc, false)
+
+		for id, n := range ids {
+			if n > 1 {
+				t.Errorf("%s: id %q is used %d times", name, id, n)
+			}
+		}
+		for _, c := range controls {
+			id, _ := attr(c, "id")
+			_, aria := attr(c, "aria-label")
+			_, ariaBy := attr(c, "aria-labelledby")
+			if !(id != "" && labelFor[id]) && !aria && !ariaBy {
+				fieldName, _ := attr(c, "name")
+				t.Errorf("%s: <%s name=%q> has no label", name, c.Data, fieldName)
+			}
+		}
+	}
+}
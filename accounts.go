@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/markbates/goth"
+)
+
+// Subscribers linked to the user who logged in with their address, so
+// /account can show the subscription. Only an address the provider vouches
+// for links, and a subscriber belongs to at most one user: the first
+// verified login claims it, which keeps the rule in users.go that two
+// providers reporting the same email never reach each other's data.
+
+// verifiedLoginEmail returns the login's normalized address when the
+// provider vouches for it, "" otherwise. Google says so per login; GitHub
+// only hands out verified addresses (public profile emails must be
+// verified, and goth falls back to the verified primary). Facebook
+// promises neither.
+func verifiedLoginEmail(u goth.User) string {
+	if validateEmail(u.Email) != nil {
+		return ""
+	}
+	email := normalizeEmail(u.Email)
+	switch u.Provider {
+	case "google":
+		if verified, _ := u.RawData["verified_email"].(bool); verified {
+			return email
+		}
+	case "github":
+		return email
+	}
+	return ""
+}
+
+// linkSubscriber runs inside the login transaction. It drops a link made
+// through an address the login no longer reports as verified, then claims
+// the unlinked subscriber with the current one.
+func linkSubscriber(ctx context.Context, tx *sql.Tx, userID int64, u goth.User) error {
+	email := verifiedLoginEmail(u)
+	if _, err := tx.ExecContext(ctx, "UPDATE subscribers SET user_id = NULL WHERE user_id = ? AND email != ?", userID, email); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE subscribers SET user_id = ? WHERE email = ? AND user_id IS NULL", userID, email)
+	return err
+}
+
+type accountSubscription struct {
+	Email  string `json:"email"`
+	Status string `json:"status"` // pending, subscribed or unsubscribed
+	// The signed unsubscribe link while subscribed, the signup page otherwise
+	ManageURL string `json:"manage_url"`
+}
+
+type accountData struct {
+	Name         string               `json:"name"`
+	Email        string               `json:"email"`
+	Provider     string               `json:"provider"`
+	Subscription *accountSubscription `json:"subscription"` // nil when none is linked
+	CSRFField    template.HTML        `json:"-"`
+}
+
+var accountSample = accountData{
+	Name: "Sample", Email: previewRecipient, Provider: "google",
+	Subscription: &accountSubscription{Email: previewRecipient, Status: "subscribed", ManageURL: previewUnsubLink},
+	CSRFField:    `<input type="hidden" name="csrf_token" value="sample">`,
+}
+
+var accountPage = template.Must(template.New("account").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Your account</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; text-align: center; }
+    section { max-width: 32rem; margin: 1.5rem auto; }
+    button { margin-top: 1rem; padding: 0.5rem 1.5rem; }
+  </style>
+</head>
+<body>
+  <main>
+    <section>
+      <h1>Your account <span lang="ar" dir="rtl">/ حسابك</span></h1>
+      <p>Signed in as {{.Name}} ({{.Email}}) with {{.Provider}}.</p>
+    </section>
+    <section>
+      <h2>Newsletter <span lang="ar" dir="rtl">/ النشرة البريدية</span></h2>
+      {{with .Subscription}}
+      <p>{{.Email}}: <strong>{{.Status}}</strong></p>
+      <a href="{{.ManageURL}}">{{if eq .Status "subscribed"}}Unsubscribe / إلغاء الاشتراك{{else}}Subscribe / اشترك{{end}}</a>
+      {{else}}
+      <p>No subscription is linked to this account. <a href="/subscribe">Subscribe / اشترك</a></p>
+      {{end}}
+    </section>
+    <form method="POST" action="/account/delete">
+      {{.CSRFField}}
+      <button type="submit">Delete account / حذف الحساب</button>
+    </form>
+    <p><small>Deleting the account doesn't unsubscribe you.</small></p>
+  </main>
+</body>
+</html>
+`))
+
+// handleAccount serves GET /account: the logged-in user and their linked
+// subscription, as HTML or JSON for Accept: application/json.
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.sessionUserID(r)
+	var data accountData
+	var name, email sql.NullString
+	err := s.db.QueryRowContext(r.Context(), "SELECT name, email, provider FROM users WHERE id = ?", userID).Scan(&name, &email, &data.Provider)
+	if err == sql.ErrNoRows {
+		// Deleted elsewhere; the cookie outlived it
+		s.endUserSession(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to load account: "+err.Error())
+		return
+	}
+	data.Name, data.Email = name.String, email.String
+
+	var sub accountSubscription
+	var id int
+	var verified, unsubscribed bool
+	err = s.db.QueryRowContext(r.Context(), "SELECT id, email, verified, unsubscribed_at IS NOT NULL FROM subscribers WHERE user_id = ?", userID).
+		Scan(&id, &sub.Email, &verified, &unsubscribed)
+	switch {
+	case err == nil:
+		sub.Status = subscriptionStatus(verified, unsubscribed)
+		sub.ManageURL = "/subscribe"
+		if sub.Status == "subscribed" {
+			sub.ManageURL = unsubscribeLink(id, sub.Email)
+		}
+		data.Subscription = &sub
+	case err != sql.ErrNoRows:
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to load subscription: "+err.Error())
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, data)
+		return
+	}
+	data.CSRFField = csrfField(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := accountPage.Execute(w, data); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
+
+// handleDeleteAccount serves POST /account/delete. The user, its provider
+// account and the subscriber link go; the subscription itself stays.
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.sessionUserID(r)
+	if err := s.deleteUser(r.Context(), userID); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Could not delete account: "+err.Error())
+		return
+	}
+	s.endUserSession(w, r)
+	log.Printf("👋 Deleted user %d", userID)
+	renderMessagePage(w, http.StatusOK, messagePageData{
+		Title:       "Account deleted",
+		Message:     "Your account has been deleted. Your newsletter subscription, if any, is unchanged.",
+		ArabicTitle: "تم حذف الحساب", ArabicMessage: "تم حذف حسابك. لم يتغير اشتراكك في النشرة البريدية.",
+	})
+}
+
+func (s *Server) deleteUser(ctx context.Context, userID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE subscribers SET user_id = NULL WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	// The provider account holds the sealed access token
+	_, err = tx.ExecContext(ctx, `DELETE FROM oauth_accounts WHERE (provider, provider_user_id) IN
+		(SELECT provider, provider_user_id FROM users WHERE id = ?)`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminToken is ADMIN_TOKEN; empty disables it.
+var adminToken string
+
+// adminOnly requires Authorization: Bearer <ADMIN_TOKEN>, or an unexpired
+// emergency token issued over the control socket. With neither configured
+// every request is refused, never allowed.
+func (s *Server) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, hasBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		ok := hasBearer && ((adminToken != "" && adminTokenMatches(got, adminToken)) || emergencyTokenValid(got))
+
+		if !ok {
+			if hasBearer {
+				s.recordSecurityEvent(r, securityAdminAuthFailed, r.URL.Path)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminActor names the credential behind an admin request, for audit
+// records. There are no per-person admin accounts.
+func adminActor(r *http.Request) string {
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminToken != "" && adminTokenMatches(got, adminToken) {
+		return "admin-token"
+	}
+	return "emergency-token"
+}
+
+// adminTokenMatches compares digests so that neither the contents nor the
+// length of the configured token leaks through timing.
+func adminTokenMatches(got, want string) bool {
+	g := sha256.Sum256([]byte(got))
+	w := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(g[:], w[:]) == 1
+}
+
+// Emergency admin tokens, kept in memory only (by SHA-256) so a restart
+// revokes them all.
+var emergencyTokens = struct {
+	sync.Mutex
+	expires map[[sha256.Size]byte]time.Time
+}{expires: make(map[[sha256.Size]byte]time.Time)}
+
+func issueEmergencyToken(ttl time.Duration) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := b64.EncodeToString(raw)
+	expires := time.Now().Add(ttl)
+
+	emergencyTokens.Lock()
+	defer emergencyTokens.Unlock()
+	for k, exp := range emergencyTokens.expires {
+		if time.Now().After(exp) {
+			delete(emergencyTokens.expires, k)
+		}
+	}
+	emergencyTokens.expires[sha256.Sum256([]byte(token))] = expires
+	return token, expires, nil
+}
+
+func emergencyTokenValid(token string) bool {
+	emergencyTokens.Lock()
+	defer emergencyTokens.Unlock()
+	exp, ok := emergencyTokens.expires[sha256.Sum256([]byte(token))]
+	return ok && time.Now().Before(exp)
+}
+
+func initAdmin(c *Config) {
+	adminToken = c.AdminToken
+	if adminToken == "" {
+		log.Println("⚠️ ADMIN_TOKEN is not set; admin endpoints will refuse every request")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminToken(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+
+	if resp, body := do(t, ts, http.MethodGet, "/subscribers", nil, "Authorization", "Bearer "+testAdminToken); resp.StatusCode != http.StatusOK {
+		t.Fatalf("the admin token = %d %q, want 200", resp.StatusCode, body)
+	}
+
+	for _, auth := range []string{
+		"",
+		"Bearer ",
+		"Bearer wrong-token",
+		"Bearer " + testAdminToken[:len(testAdminToken)-1],
+		"Bearer " + testAdminToken + "x",
+		"Bearer " + strings.ToUpper(testAdminToken),
+		"bearer " + testAdminToken,
+		"Basic " + testAdminToken,
+		testAdminToken,
+	} {
+		resp, _ := do(t, ts, http.MethodGet, "/subscribers", nil, "Authorization", auth)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q = %d, want 401", auth, resp.StatusCode)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); got != `Bearer realm="admin"` {
+			t.Errorf("Authorization %q: WWW-Authenticate = %q", auth, got)
+		}
+	}
+
+	// Only wrong bearer tokens count as attacks; a bare "Bearer " arrives
+	// trimmed to "Bearer" and carries none
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM security_events WHERE kind = ?", securityAdminAuthFailed).Scan(&n)
+	if n != 4 {
+		t.Errorf("%d admin_auth_failed events, want 4", n)
+	}
+}
+
+func TestAdminWithoutToken(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"ADMIN_TOKEN": ""})
+
+	for _, auth := range []string{"", "Bearer ", "Bearer " + testAdminToken} {
+		if resp, _ := do(t, ts, http.MethodGet, "/subscribers", nil, "Authorization", auth); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("no ADMIN_TOKEN, Authorization %q = %d, want 401", auth, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminEmergencyToken(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"ADMIN_TOKEN": ""})
+
+	token, _, err := issueEmergencyToken(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := do(t, ts, http.MethodGet, "/subscribers", nil, "Authorization", "Bearer "+token); resp.StatusCode != http.StatusOK {
+		t.Errorf("emergency token = %d, want 200", resp.StatusCode)
+	}
+
+	expired, _, err := issueEmergencyToken(-time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := do(t, ts, http.MethodGet, "/subscribers", nil, "Authorization", "Bearer "+expired); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expired emergency token = %d, want 401", resp.StatusCode)
+	}
+}
+
+// TestAdminTokenMatchesConstantTime compares how long a guess that is
+// wrong in its first byte takes against one wrong only in its last byte.
+// A byte-by-byte comparison returns at the first difference, which on a
+// 4 KiB token is a large, measurable gap.
+func TestAdminTokenMatchesConstantTime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+	want := strings.Repeat("k", 4096)
+	early := "x" + want[1:]
+	late := want[:len(want)-1] + "x"
+	if adminTokenMatches(early, want) || adminTokenMatches(late, want) || !adminTokenMatches(want, want) {
+		t.Fatal("adminTokenMatches got the answer wrong")
+	}
+
+	median := func(guess string) time.Duration {
+		samples := make([]time.Duration, 201)
+		for i := range samples {
+			start := time.Now()
+			for range 50 {
+				adminTokenMatches(guess, want)
+			}
+			samples[i] = time.Since(start)
+		}
+		slices.Sort(samples)
+		return samples[len(samples)/2]
+	}
+	median(late) // warm up
+	e, l := median(early), median(late)
+	if ratio := float64(l) / float64(e); ratio > 1.5 || ratio < 1/1.5 {
+		t.Errorf("a guess wrong in its last byte takes %s, one wrong in its first %s", l, e)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content negotiation for handlers that serve both browsers and scripts:
+// Accept: application/json gets JSON, anything else keeps the plain-text
+// responses. A JSON request body also implies a JSON response.
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || isJSONRequest(r)
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "application/json"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep & in links readable
+	enc.Encode(v)
+}
+
+// writeError sends {"error": msg} to JSON clients and msg as text otherwise.
+// The emoji prefix used in plain-text errors is dropped from JSON.
+// Server-side failures are also kept in the error rings.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if status >= http.StatusInternalServerError {
+		s.logError(r.Context(), componentHTTP, msg, nil, "status", status, "path", r.URL.Path)
+	}
+	if wantsJSON(r) {
+		writeJSON(w, status, map[string]string{"error": strings.TrimLeft(msg, "❌⚠️ ")})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// writeRetryLater answers a 429 or 503 the client should retry: the
+// Retry-After header carries retryAfter (seconds), and JSON clients get it
+// again as retry_after_seconds next to the error.
+func (s *Server) writeRetryLater(w http.ResponseWriter, r *http.Request, status, retryAfter int, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	if !wantsJSON(r) {
+		s.writeError(w, r, status, msg)
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		s.logError(r.Context(), componentHTTP, msg, nil, "status", status, "path", r.URL.Path)
+	}
+	writeJSON(w, status, map[string]any{"error": strings.TrimLeft(msg, "❌⚠️ "), "retry_after_seconds": retryAfter})
+}
+
+// respond sends payload to JSON clients and text to everyone else.
+func respond(w http.ResponseWriter, r *http.Request, status int, text string, payload any) {
+	if wantsJSON(r) {
+		writeJSON(w, status, payload)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
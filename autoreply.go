@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Optional acknowledgment for contact-form submissions, enabled with
+// AUTO_REPLY_ENABLED=1. Templates live in templates/auto_reply/<lang>.txt
+// and are re-read on every send, so edits take effect without a restart.
+
+const autoReplyTemplateDir = "templates/auto_reply"
+
+// Marks the reply as automatic so other autoresponders stay quiet (RFC 3834).
+var autoReplyHeaders = map[string]string{
+	"Auto-Submitted":           "auto-replied",
+	"X-Auto-Response-Suppress": "All",
+}
+
+func autoReplyEnabled() bool {
+	return currentSettings().AutoReplyEnabled
+}
+
+// sendAutoReply acknowledges a stored message, at most once per address per
+// day so two autoresponders can't ping-pong.
+func (s *Server) sendAutoReply(messageID int64, email, message string) {
+	var recent int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM auto_replies WHERE email = ? AND sent_at >= datetime('now', '-1 day')",
+		email).Scan(&recent)
+	if err != nil {
+		log.Println("⚠️ Auto-reply check failed:", err)
+		return
+	}
+	if recent > 0 {
+		return
+	}
+
+	subject, body, err := loadAutoReplyTemplate(messageLang(message))
+	if err != nil {
+		log.Println("⚠️ Auto-reply template unavailable:", err)
+		return
+	}
+
+	if err := s.sendEmail(email, subject, body, autoReplyHeaders); err != nil {
+		return
+	}
+
+	_, err = s.db.Exec("INSERT INTO auto_replies(message_id, email) VALUES(?, ?)", messageID, email)
+	if err != nil {
+		log.Println("⚠️ Failed to record auto-reply:", err)
+		return
+	}
+	log.Println("↩️ Auto-reply sent to:", email)
+}
+
+// loadAutoReplyTemplate reads "Subject: ..." from the first line and the body
+// after the following blank line, falling back to English.
+func loadAutoReplyTemplate(lang string) (subject, body string, err error) {
+	data, err := os.ReadFile(filepath.Join(autoReplyTemplateDir, lang+".txt"))
+	if os.IsNotExist(err) && lang != defaultLang {
+		data, err = os.ReadFile(filepath.Join(autoReplyTemplateDir, defaultLang+".txt"))
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	header, body, _ := strings.Cut(string(data), "\n")
+	subject = strings.TrimSpace(strings.TrimPrefix(header, "Subject:"))
+	return subject, strings.TrimSpace(body) + "\n", nil
+}
+
+// messageLang picks the reply template language: Arabic for Arabic script
+// and Arabizi, English otherwise (there is no French template yet).
+func messageLang(text string) string {
+	switch lang, _ := detectLanguage(text); lang {
+	case langArabic, langArabizi:
+		return "ar"
+	}
+	return defaultLang
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// Broadcasts send one message to every verified, still-subscribed address,
+// with the body expanded per recipient (placeholders are in campaign.go).
+// POST /admin/broadcast answers as soon as the broadcast is recorded; a pool
+// of BROADCAST_WORKERS goroutines does the sending and the final counts land
+// in the broadcasts row, readable from GET /admin/broadcast/{id}.
+
+const (
+	defaultBroadcastWorkers = 5
+	maxBroadcastSubject     = 200
+	maxBroadcastBody        = 20000
+)
+
+var broadcastWorkers = defaultBroadcastWorkers
+
+// Counters for broadcasts still sending, so the status endpoint shows
+// progress before the row is final.
+var runningBroadcasts sync.Map // int64 -> *broadcastProgress
+
+// broadcastsRunning lets shutdown wait for runBroadcast goroutines.
+var broadcastsRunning sync.WaitGroup
+
+type broadcastProgress struct {
+	sent, failed atomic.Int64
+}
+
+func initBroadcasts(c *Config) {
+	broadcastWorkers = c.BroadcastWorkers
+}
+
+type broadcastSummary struct {
+	ID             int64   `json:"id"`
+	Subject        string  `json:"subject"`
+	Status         string  `json:"status"`
+	RecipientCount int     `json:"recipient_count"`
+	Sent           int64   `json:"sent"`
+	Failed         int64   `json:"failed"`
+	SentAt         string  `json:"sent_at"`
+	CompletedAt    *string `json:"completed_at"`
+}
+
+// handleBroadcast serves POST /admin/broadcast with {"subject", "body"}.
+// It refuses while BASE_URL is unset: every message carries an unsubscribe
+// link, which must not point at localhost.
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if siteBaseURL == nil {
+		s.writeError(w, r, http.StatusConflict, "❌ BASE_URL is not set; broadcasts need it for their unsubscribe links")
+		return
+	}
+	if err := parseLimitedForm(w, r); err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+	subject, err := formValue(r, "subject", maxBroadcastSubject, true, false)
+	if err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+	body, err := formValue(r, "body", maxBroadcastBody, true, true)
+	if err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+	tmpl, err := parseCampaignBody(body)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "❌ Invalid body template: "+err.Error())
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), "SELECT id, email FROM subscribers WHERE verified = 1 AND unsubscribed_at IS NULL ORDER BY id")
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to fetch subscribers")
+		return
+	}
+	var recipients []broadcastRecipient
+	for rows.Next() {
+		var rcpt broadcastRecipient
+		if err := rows.Scan(&rcpt.id, &rcpt.email); err != nil {
+			rows.Close()
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read subscribers")
+			return
+		}
+		recipients = append(recipients, rcpt)
+	}
+	rows.Close()
+
+	var id int64
+	err = s.db.QueryRowContext(r.Context(), "INSERT INTO broadcasts(subject, body, recipient_count) VALUES(?, ?, ?) RETURNING id",
+		subject, body, len(recipients)).Scan(&id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to record broadcast")
+		return
+	}
+
+	progress := &broadcastProgress{}
+	runningBroadcasts.Store(id, progress)
+	broadcastsRunning.Add(1)
+	go s.runBroadcast(id, subject, tmpl, recipients, progress)
+	log.Printf("📣 Broadcast %d queued for %d subscribers", id, len(recipients))
+
+	summary, err := s.loadBroadcast(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read broadcast")
+		return
+	}
+	w.Header().Set("Location", "/admin/broadcast/"+strconv.FormatInt(id, 10))
+	writeJSON(w, http.StatusAccepted, summary)
+}
+
+type broadcastRecipient struct {
+	id    int
+	email string
+}
+
+// runBroadcast fans the recipients out to the worker pool. A failed address
+// is logged and counted; it never stops the rest of the broadcast.
+func (s *Server) runBroadcast(id int64, subject string, tmpl *template.Template, recipients []broadcastRecipient, progress *broadcastProgress) {
+	jobs := make(chan broadcastRecipient)
+	var wg sync.WaitGroup
+	for range min(broadcastWorkers, max(len(recipients), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rcpt := range jobs {
+				body, err := renderCampaignBody(tmpl, rcpt.id)
+				if err == nil {
+					err = s.sendEmail(rcpt.email, subject, body, nil)
+				}
+				if err != nil {
+					log.Printf("❌ Broadcast %d: failed to send to %s: %v", id, rcpt.email, err)
+					progress.failed.Add(1)
+					continue
+				}
+				progress.sent.Add(1)
+			}
+		}()
+	}
+	for _, rcpt := range recipients {
+		jobs <- rcpt
+	}
+	close(jobs)
+	wg.Wait()
+
+	sent, failed := progress.sent.Load(), progress.failed.Load()
+	_, err := s.db.Exec("UPDATE broadcasts SET sent_count = ?, failed_count = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?",
+		sent, failed, id)
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Broadcast: could not save results", err, "broadcast_id", id)
+	}
+	runningBroadcasts.Delete(id)
+	broadcastsRunning.Done()
+	log.Printf("✅ Broadcast %d finished: %d sent, %d failed", id, sent, failed)
+}
+
+// waitForBroadcasts waits at shutdown for running broadcasts, up to the
+// deadline in ctx.
+func waitForBroadcasts(ctx context.Context) {
+	running := 0
+	runningBroadcasts.Range(func(_, _ any) bool { running++; return true })
+	if running == 0 {
+		return
+	}
+	log.Printf("🛑 Waiting for %d running broadcasts", running)
+	done := make(chan struct{})
+	go func() {
+		broadcastsRunning.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("⚠️ Broadcasts still sending at the shutdown deadline will show as interrupted")
+	}
+}
+
+func (s *Server) loadBroadcast(ctx context.Context, id int64) (broadcastSummary, error) {
+	sum := broadcastSummary{ID: id}
+	var completed sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT subject, recipient_count, sent_count, failed_count,
+		strftime('%Y-%m-%dT%H:%M:%SZ', sent_at), strftime('%Y-%m-%dT%H:%M:%SZ', completed_at)
+		FROM broadcasts WHERE id = ?`, id).
+		Scan(&sum.Subject, &sum.RecipientCount, &sum.Sent, &sum.Failed, &sum.SentAt, &completed)
+	if err != nil {
+		return sum, err
+	}
+
+	switch {
+	case completed.Valid:
+		sum.Status = "completed"
+		sum.CompletedAt = &completed.String
+	default:
+		if p, ok := runningBroadcasts.Load(id); ok {
+			sum.Status = "sending"
+			sum.Sent, sum.Failed = p.(*broadcastProgress).sent.Load(), p.(*broadcastProgress).failed.Load()
+		} else {
+			// Never finished: the process stopped mid-broadcast
+			sum.Status = "interrupted"
+		}
+	}
+	return sum, nil
+}
+
+// handleBroadcastStatus serves GET /admin/broadcast/{id}.
+func (s *Server) handleBroadcastStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id must be an integer"})
+		return
+	}
+	summary, err := s.loadBroadcast(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "broadcast not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read broadcast"})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
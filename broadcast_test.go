@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBroadcastNeedsBaseURL(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	resp, body := do(t, ts, http.MethodPost, "/admin/broadcast", map[string]string{"subject": "News", "body": "Hello"},
+		"Authorization", "Bearer "+testAdminToken)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("broadcast without BASE_URL = %d %q, want 409", resp.StatusCode, body)
+	}
+}
+
+func TestBroadcastWithBaseURL(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"BASE_URL": "https://news.example.com"})
+	addVerified(t, s, 2)
+
+	resp, body := do(t, ts, http.MethodPost, "/admin/broadcast", map[string]string{"subject": "News", "body": "Hello"},
+		"Authorization", "Bearer "+testAdminToken)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("broadcast = %d %q, want 202", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Broadcast bodies are text/template sources expanded once per recipient:
+//
+//	{{.PrefsURL}}         BASE_URL/preferences with the recipient's sub= token
+//	{{.ArchiveURL}}       BASE_URL/archive with the recipient's sub= token
+//	{{.Token "purpose"}}  a signed token for that purpose (see signToken)
+//
+// With SIGN_SITE_LINKS=1 every other link into BASE_URL also gets sub=.
+// Links to any other host are never touched. sub= carries the subscriber id,
+// never the address, and expires after campaignTokenTTL; the site checks it
+// with verifyToken("sub", ...).
+
+const (
+	campaignTokenTTL = 7 * 24 * time.Hour
+	subTokenPurpose  = "sub"
+)
+
+var (
+	siteBaseURL   *url.URL
+	signSiteLinks bool
+)
+
+var errNoBaseURL = errors.New("BASE_URL is not set")
+
+// localBaseURL stands in for an unset BASE_URL in verification and
+// unsubscribe links, so a development server works out of the box.
+var localBaseURL = &url.URL{Scheme: "http", Host: "localhost:8080"}
+
+func initCampaignLinks(c *Config) {
+	siteBaseURL, signSiteLinks = c.BaseURL, c.SignSiteLinks
+	if siteBaseURL == nil {
+		log.Println("⚠️ BASE_URL is not set; emailed links point to " + localBaseURL.String() + " and broadcasts are refused")
+	}
+}
+
+// siteLink is BASE_URL (or localBaseURL) with path appended and query set,
+// built the same way as the campaign links.
+func siteLink(path string, query url.Values) string {
+	base := siteBaseURL
+	if base == nil {
+		base = localBaseURL
+	}
+	u := *base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// campaignData is the template data for one recipient.
+type campaignData struct {
+	subscriberID int
+	now          time.Time
+	base         *url.URL
+}
+
+func (d campaignData) Token(purpose string) string {
+	return signToken(purpose, strconv.Itoa(d.subscriberID), d.now.Add(campaignTokenTTL))
+}
+
+func (d campaignData) PrefsURL() (string, error)   { return d.siteURL("/preferences") }
+func (d campaignData) ArchiveURL() (string, error) { return d.siteURL("/archive") }
+
+func (d campaignData) siteURL(path string) (string, error) {
+	if d.base == nil {
+		return "", errNoBaseURL
+	}
+	u := *d.base
+	u.Path += path
+	u.RawQuery = url.Values{"sub": {d.Token(subTokenPurpose)}}.Encode()
+	return u.String(), nil
+}
+
+func parseCampaignBody(body string) (*template.Template, error) {
+	t, err := template.New("broadcast").Funcs(i18nFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	// Render once with dummy data so a bad placeholder fails the request,
+	// not every send.
+	if _, err := renderCampaignBody(t, 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderCampaignBody expands the placeholders for one subscriber, then adds
+// sub= to BASE_URL links when enabled. Anything that rewrites links for
+// click tracking must run on this output, so the signed links are what get
+// wrapped.
+func renderCampaignBody(t *template.Template, subscriberID int) (string, error) {
+	return renderCampaign(t, campaignData{subscriberID: subscriberID, now: time.Now(), base: siteBaseURL}, signSiteLinks)
+}
+
+func renderCampaign(t *template.Template, d campaignData, sign bool) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", err
+	}
+	if !sign {
+		return b.String(), nil
+	}
+	return signLinks(b.String(), d.base, d.Token(subTokenPurpose)), nil
+}
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// signLinks appends sub= to every link into BASE_URL that doesn't already
+// carry one. Trailing punctuation is left outside the link.
+func signLinks(body string, base *url.URL, sub string) string {
+	return linkPattern.ReplaceAllStringFunc(body, func(link string) string {
+		trimmed := strings.TrimRight(link, ".,;:!?)]")
+		suffix := link[len(trimmed):]
+
+		u, err := url.Parse(trimmed)
+		if err != nil || !isSiteURL(u, base) {
+			return link
+		}
+		q := u.Query()
+		if q.Has("sub") {
+			return link
+		}
+		q.Set("sub", sub)
+		u.RawQuery = q.Encode()
+		return u.String() + suffix
+	})
+}
+
+// isSiteURL matches scheme and host exactly, and the path must sit under
+// BASE_URL's path, so lookalike hosts such as example.com.evil.net don't
+// match.
+func isSiteURL(u, base *url.URL) bool {
+	if base == nil || !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+		return false
+	}
+	return base.Path == "" || u.Path == base.Path || strings.HasPrefix(u.Path, base.Path+"/")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+var campaignBase = &url.URL{Scheme: "https", Host: "example.com", Path: "/news"}
+
+func TestCampaignPlaceholders(t *testing.T) {
+	newTestServer(t, map[string]string{"BASE_URL": campaignBase.String()})
+	tmpl, err := parseCampaignBody(`Prefs: {{.PrefsURL}} Archive: {{.ArchiveURL}} Code: {{.Token "giveaway"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := campaignData{subscriberID: 42, now: time.Now(), base: campaignBase}
+	out, err := renderCampaign(tmpl, d, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := url.Values{"sub": {d.Token(subTokenPurpose)}}.Encode()
+	want := "Prefs: https://example.com/news/preferences?" + sub +
+		" Archive: https://example.com/news/archive?" + sub +
+		" Code: " + d.Token("giveaway")
+	if out != want {
+		t.Errorf("rendered\n%s\nwant\n%s", out, want)
+	}
+	if id, err := verifyToken(subTokenPurpose, d.Token(subTokenPurpose)); err != nil || id != "42" {
+		t.Errorf("sub= token verifies to %q, %v, want 42", id, err)
+	}
+	if _, err := verifyToken(subTokenPurpose, d.Token("giveaway")); err == nil {
+		t.Error("a giveaway token passed as sub=")
+	}
+
+	// Without BASE_URL there is nothing to link to
+	if _, err := renderCampaign(tmpl, campaignData{subscriberID: 42, now: time.Now()}, false); err == nil {
+		t.Error("{{.PrefsURL}} rendered without BASE_URL")
+	}
+}
+
+func TestParseCampaignBodyRejects(t *testing.T) {
+	newTestServer(t, map[string]string{"BASE_URL": campaignBase.String()})
+	for _, body := range []string{
+		"{{.Email}}",           // no such field: the address is never exposed
+		"{{.PrefsUrl}}",        // misspelled
+		`{{.Token}}`,           // missing the purpose
+		"{{.PrefsURL",          // unclosed action
+		`{{template "other"}}`, // undefined template
+	} {
+		if _, err := parseCampaignBody(body); err == nil {
+			t.Errorf("parseCampaignBody(%q) accepted a bad placeholder", body)
+		}
+	}
+	if _, err := parseCampaignBody("Hello {{.PrefsURL}}"); err != nil {
+		t.Errorf("a valid body was refused: %v", err)
+	}
+}
+
+func TestSignLinks(t *testing.T) {
+	const sub = "TOKEN"
+	for _, tc := range []struct{ in, want string }{
+		{"https://example.com/news/post/1", "https://example.com/news/post/1?sub=TOKEN"},
+		{"https://EXAMPLE.com/news", "https://EXAMPLE.com/news?sub=TOKEN"},
+		{"https://example.com/news/post?x=1", "https://example.com/news/post?sub=TOKEN&x=1"},
+		// An existing sub= is kept as it is
+		{"https://example.com/news/prefs?sub=OTHER", "https://example.com/news/prefs?sub=OTHER"},
+		// Trailing punctuation stays outside the link
+		{"Read https://example.com/news/post.", "Read https://example.com/news/post?sub=TOKEN."},
+		{"(see https://example.com/news/post)!", "(see https://example.com/news/post?sub=TOKEN)!"},
+		{"https://example.com/news/a, https://example.com/news/b;", "https://example.com/news/a?sub=TOKEN, https://example.com/news/b?sub=TOKEN;"},
+		// Other sites, lookalike hosts and paths beside BASE_URL's are untouched
+		{"https://example.com.evil.net/news/post", "https://example.com.evil.net/news/post"},
+		{"https://evil.net/?u=https://example.com/news", "https://evil.net/?u=https://example.com/news"},
+		{"https://other.org/news", "https://other.org/news"},
+		{"http://example.com/news/post", "http://example.com/news/post"},
+		{"https://example.com/newsletter", "https://example.com/newsletter"},
+		{"https://example.com/", "https://example.com/"},
+	} {
+		if got := signLinks(tc.in, campaignBase, sub); got != tc.want {
+			t.Errorf("signLinks(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsSiteURL(t *testing.T) {
+	root := &url.URL{Scheme: "https", Host: "example.com"}
+	for _, tc := range []struct {
+		link string
+		base *url.URL
+		want bool
+	}{
+		{"https://example.com/anything", root, true},
+		{"https://example.com", root, true},
+		{"https://example.com:8443/", root, false},
+		{"https://sub.example.com/", root, false},
+		{"https://example.com.evil.net/", root, false},
+		{"https://example.com/news", campaignBase, true},
+		{"https://example.com/news/", campaignBase, true},
+		{"https://example.com/news2", campaignBase, false},
+		{"https://example.com/", campaignBase, false},
+		{"https://example.com/news", nil, false},
+	} {
+		u, err := url.Parse(tc.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isSiteURL(u, tc.base); got != tc.want {
+			t.Errorf("isSiteURL(%q, %v) = %v, want %v", tc.link, tc.base, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package client is a Go client for the subscription server's JSON API:
+// public signup plus the admin endpoints under /api/v1 and /admin.
+//
+// It only depends on the standard library, so it can be imported on its
+// own by partner integrations.
+//
+//	c, err := client.New("https://example.com", client.WithToken(os.Getenv("ADMIN_TOKEN")))
+//	res, err := c.Subscribe(ctx, "amira@example.com")
+//
+// Requests refused with 429 or 503 are retried after the server's
+// Retry-After, up to WithMaxRetries times; other failures come back as
+// *Error, which matches the sentinel errors with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultMaxRetryWait = time.Minute
+	// Used when a 429 or 503 carries no usable Retry-After
+	defaultRetryWait = time.Second
+	// Error bodies are short; don't read a misbehaving proxy's page whole
+	maxErrorBody = 64 << 10
+)
+
+// Client talks to one server. It is safe for concurrent use.
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	token        string
+	maxRetries   int
+	maxRetryWait time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates admin calls with Authorization: Bearer <token>,
+// either the server's ADMIN_TOKEN or an emergency token from adminctl.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout or a
+// custom transport.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithMaxRetries sets how often a 429 or 503 is retried (default 3; 0
+// disables retries).
+func WithMaxRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = max(n, 0) }
+}
+
+// WithMaxRetryWait caps how long one Retry-After is honored (default one
+// minute). A server asking for longer gets the error back instead.
+func WithMaxRetryWait(d time.Duration) Option {
+	return func(c *Client) { c.maxRetryWait = d }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "https://example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL must be an absolute http(s) URL, got %q", baseURL)
+	}
+	c := &Client{
+		baseURL:      u,
+		httpClient:   http.DefaultClient,
+		maxRetries:   defaultMaxRetries,
+		maxRetryWait: defaultMaxRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Subscription states returned by Subscribe and SubscriptionStatus.
+const (
+	StatusVerificationSent  = "verification_sent"
+	StatusAlreadySubscribed = "already_subscribed"
+
+	StatusPending      = "pending"
+	StatusSubscribed   = "subscribed"
+	StatusUnsubscribed = "unsubscribed"
+)
+
+// SubscribeResult is the outcome of a signup.
+type SubscribeResult struct {
+	Status string `json:"status"` // StatusVerificationSent or StatusAlreadySubscribed
+	Email  string `json:"email"`  // normalized by the server
+}
+
+// Subscribe signs an address up. The server sends the confirmation email;
+// the address is subscribed once its owner clicks the link.
+func (c *Client) Subscribe(ctx context.Context, email string) (*SubscribeResult, error) {
+	var res SubscribeResult
+	err := c.do(ctx, http.MethodPost, "/subscriber/email", map[string]string{"email": email}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Subscriber is one address as the admin API shows it.
+type Subscriber struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"verified"`
+	CreatedAt string `json:"created_at"` // RFC 3339, UTC
+	// Only set by SubscriptionStatus: StatusPending, StatusSubscribed or
+	// StatusUnsubscribed
+	Status string `json:"status,omitempty"`
+}
+
+// SubscriptionStatus looks an address up (admin). An unknown address is
+// an error matching ErrNotFound.
+func (c *Client) SubscriptionStatus(ctx context.Context, email string) (*Subscriber, error) {
+	var s Subscriber
+	if err := c.do(ctx, http.MethodGet, "/api/v1/subscribers/"+url.PathEscape(email), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SubscriberPage is one page of ListSubscribersPage.
+type SubscriberPage struct {
+	Data     []Subscriber `json:"data"`
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PerPage  int          `json:"per_page"`
+	NextPage *string      `json:"next_page"`
+}
+
+// ListSubscribersPage fetches one page of subscribers who haven't
+// unsubscribed (admin). The server clamps perPage to 1-200.
+func (c *Client) ListSubscribersPage(ctx context.Context, page, perPage int) (*SubscriberPage, error) {
+	q := url.Values{"page": {strconv.Itoa(page)}, "per_page": {strconv.Itoa(perPage)}}
+	var p SubscriberPage
+	if err := c.do(ctx, http.MethodGet, "/api/v1/subscribers?"+q.Encode(), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListSubscribers iterates over every subscriber, fetching perPage at a
+// time. Iteration stops after the first error, which is yielded with a
+// zero Subscriber.
+func (c *Client) ListSubscribers(ctx context.Context, perPage int) iter.Seq2[Subscriber, error] {
+	return func(yield func(Subscriber, error) bool) {
+		for page := 1; ; page++ {
+			p, err := c.ListSubscribersPage(ctx, page, perPage)
+			if err != nil {
+				yield(Subscriber{}, err)
+				return
+			}
+			for _, s := range p.Data {
+				if !yield(s, nil) {
+					return
+				}
+			}
+			if p.NextPage == nil || len(p.Data) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Campaign is a broadcast to every subscribed address.
+type Campaign struct {
+	ID             int64   `json:"id"`
+	Subject        string  `json:"subject"`
+	Status         string  `json:"status"` // sending, completed or interrupted
+	RecipientCount int     `json:"recipient_count"`
+	Sent           int64   `json:"sent"`
+	Failed         int64   `json:"failed"`
+	SentAt         string  `json:"sent_at"`
+	CompletedAt    *string `json:"completed_at"`
+}
+
+// CreateCampaign starts a broadcast (admin) and returns as soon as the
+// server has recorded it; poll Campaign for progress. body is a template
+// and may use {{.PrefsURL}}, {{.ArchiveURL}} and {{.Token "purpose"}}.
+func (c *Client) CreateCampaign(ctx context.Context, subject, body string) (*Campaign, error) {
+	var cp Campaign
+	err := c.do(ctx, http.MethodPost, "/admin/broadcast", map[string]string{"subject": subject, "body": body}, &cp)
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Campaign fetches a broadcast's current counts (admin).
+func (c *Client) Campaign(ctx context.Context, id int64) (*Campaign, error) {
+	var cp Campaign
+	if err := c.do(ctx, http.MethodGet, "/admin/broadcast/"+strconv.FormatInt(id, 10), nil, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// QueueFailure is a message the server gave up on.
+type QueueFailure struct {
+	ID        int64  `json:"id"`
+	Kind      string `json:"kind"`
+	Recipient string `json:"recipient"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// QueueStatus is the state of the server's outgoing email queue.
+type QueueStatus struct {
+	Workers        int            `json:"workers"`
+	Capacity       int            `json:"capacity"`
+	InFlight       int            `json:"in_flight"`
+	Counts         map[string]int `json:"counts"` // by status: pending, sending, sent, failed
+	OldestPending  *string        `json:"oldest_pending"`
+	RecentFailures []QueueFailure `json:"recent_failures"`
+}
+
+// QueueStatus reports the outgoing email queue (admin).
+func (c *Client) QueueStatus(ctx context.Context) (*QueueStatus, error) {
+	var q QueueStatus
+	if err := c.do(ctx, http.MethodGet, "/admin/email-queue", nil, &q); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// do sends one API call, retrying 429 and 503, and decodes a 2xx JSON
+// body into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := c.baseURL.String() + path
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if out == nil {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("client: decoding %s %s response: %w", method, path, err)
+			}
+			return nil
+		}
+
+		apiErr := readError(resp)
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= c.maxRetries || apiErr.RetryAfter > c.maxRetryWait {
+			return apiErr
+		}
+		wait := apiErr.RetryAfter
+		if wait <= 0 {
+			wait = defaultRetryWait
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	e := &Error{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	var envelope struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(raw, &envelope) == nil && envelope.Error != "" {
+		e.Message = envelope.Error
+	} else {
+		// Some refusals (401, 405) are plain text
+		e.Message = strings.TrimSpace(string(raw))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// parseRetryAfter reads delay-seconds or an HTTP date; 0 when absent.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors for errors.Is. The server's error body carries only a
+// message, so these are keyed on the HTTP status.
+var (
+	ErrBadRequest   = sentinel("bad request")         // 400, 413, 422: bad or oversized input
+	ErrUnauthorized = sentinel("unauthorized")        // 401, 403: missing or wrong token
+	ErrNotFound     = sentinel("not found")           // 404
+	ErrRateLimited  = sentinel("rate limited")        // 429, after retries ran out
+	ErrUnavailable  = sentinel("service unavailable") // 503, e.g. maintenance mode
+	ErrServer       = sentinel("server error")        // any other 5xx
+)
+
+type sentinel string
+
+func (s sentinel) Error() string { return "client: " + string(s) }
+
+// Error is a non-2xx response.
+type Error struct {
+	StatusCode int
+	// The server's "error" field, or the plain-text body
+	Message string
+	// From the Retry-After header; 0 when absent
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the sentinel for e's status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"my-news-app/client"
+)
+
+// The client package is tested against the real routes here, since its
+// contract is whatever this server answers.
+
+func newTestClient(t *testing.T, env map[string]string, opts ...client.Option) (*Server, *client.Client) {
+	t.Helper()
+	s, ts := newTestServer(t, env)
+	c, err := client.New(ts.URL, append([]client.Option{client.WithToken(testAdminToken)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func TestClientSubscribeAndLookup(t *testing.T) {
+	s, c := newTestClient(t, nil)
+	ctx := t.Context()
+
+	res, err := c.Subscribe(ctx, " Reader@Example.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != client.StatusVerificationSent || res.Email != "reader@example.com" {
+		t.Errorf("Subscribe = %+v", res)
+	}
+
+	sub, err := c.SubscriptionStatus(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Email != "reader@example.com" || sub.Verified || sub.Status != client.StatusPending || sub.ID == 0 {
+		t.Errorf("before verifying = %+v", sub)
+	}
+	if _, err := time.Parse(time.RFC3339, sub.CreatedAt); err != nil {
+		t.Errorf("created_at %q: %v", sub.CreatedAt, err)
+	}
+
+	s.db.Exec("UPDATE subscribers SET verified = 1 WHERE email = 'reader@example.com'")
+	if sub, err := c.SubscriptionStatus(ctx, "reader@example.com"); err != nil || sub.Status != client.StatusSubscribed {
+		t.Errorf("after verifying = %+v, %v", sub, err)
+	}
+	if res, err := c.Subscribe(ctx, "reader@example.com"); err != nil || res.Status != client.StatusAlreadySubscribed {
+		t.Errorf("subscribing again = %+v, %v", res, err)
+	}
+
+	_, err = c.SubscriptionStatus(ctx, "nobody@example.com")
+	var apiErr *client.Error
+	if !errors.Is(err, client.ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Message == "" {
+		t.Errorf("unknown address: err = %v, want ErrNotFound with the server's message", err)
+	}
+}
+
+func TestClientPaging(t *testing.T) {
+	s, c := newTestClient(t, nil)
+	addVerified(t, s, 5)
+
+	p, err := c.ListSubscribersPage(t.Context(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data) != 2 || p.Total != 5 || p.Page != 1 || p.PerPage != 2 || p.NextPage == nil {
+		t.Errorf("page 1 = %+v", p)
+	}
+	if p, err := c.ListSubscribersPage(t.Context(), 3, 2); err != nil || len(p.Data) != 1 || p.NextPage != nil {
+		t.Errorf("last page = %+v, %v", p, err)
+	}
+
+	seen := map[string]bool{}
+	for sub, err := range c.ListSubscribers(t.Context(), 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[sub.Email] {
+			t.Errorf("%s listed twice", sub.Email)
+		}
+		seen[sub.Email] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("ListSubscribers gave %d subscribers, want 5", len(seen))
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	ctx := t.Context()
+
+	wrong, _ := client.New(ts.URL, client.WithToken("wrong-token"))
+	if _, err := wrong.SubscriptionStatus(ctx, "reader@example.com"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("wrong token: err = %v, want ErrUnauthorized", err)
+	}
+	anon, _ := client.New(ts.URL)
+	var apiErr *client.Error
+	if _, err := anon.Subscribe(ctx, "not-an-address"); !errors.Is(err, client.ErrBadRequest) || !errors.As(err, &apiErr) || apiErr.Message == "" {
+		t.Errorf("bad address: err = %v, want ErrBadRequest with a message", err)
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	_, c := newTestClient(t, map[string]string{"RATE_RPS": "0.01", "RATE_BURST": "1"}, client.WithMaxRetries(0))
+	ctx := t.Context()
+
+	if _, err := c.Subscribe(ctx, "first@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Subscribe(ctx, "second@example.com")
+	var apiErr *client.Error
+	if !errors.Is(err, client.ErrRateLimited) || !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 100*time.Second || apiErr.Message == "" {
+		t.Errorf("429 decoded as %+v, want Retry-After 100s and a message", apiErr)
+	}
+}
+
+func TestClientRetryAfterTooLong(t *testing.T) {
+	// A Retry-After longer than WithMaxRetryWait comes straight back
+	// instead of sleeping
+	_, c := newTestClient(t, map[string]string{"RATE_RPS": "0.01", "RATE_BURST": "1"}, client.WithMaxRetryWait(time.Second))
+	c.Subscribe(t.Context(), "first@example.com")
+
+	started := time.Now()
+	_, err := c.Subscribe(t.Context(), "second@example.com")
+	if !errors.Is(err, client.ErrRateLimited) {
+		t.Errorf("err = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("waited %s before giving up", elapsed)
+	}
+}
+
+func TestClientRetriesRateLimited(t *testing.T) {
+	// At RATE_RPS=2 the next token is half a second away: Retry-After: 1
+	_, c := newTestClient(t, map[string]string{"RATE_RPS": "2", "RATE_BURST": "1"}, client.WithMaxRetries(1))
+	c.Subscribe(t.Context(), "first@example.com")
+
+	started := time.Now()
+	res, err := c.Subscribe(t.Context(), "second@example.com")
+	if err != nil || res.Status != client.StatusVerificationSent {
+		t.Fatalf("Subscribe after one retry = %+v, %v", res, err)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("retried after %s, before the server's Retry-After", elapsed)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// runCommand dispatches maintenance subcommands given on the command line.
+// They only need the database, not a running server.
+func runCommand(c *Config, name string, args []string) {
+	switch name {
+	case "sync-legacy-file":
+		s := &Server{cfg: c, db: openDB(c.DatabasePath), log: errorLog}
+		defer s.db.Close()
+		if err := s.syncLegacyFile(); err != nil {
+			log.Fatal("❌ sync-legacy-file failed:", err)
+		}
+	case "migrate", "-migrate-only", "--migrate-only":
+		// openDB migrates; nothing else is started
+		db := openDB(c.DatabasePath)
+		db.Close()
+		log.Println("✅ Database schema is up to date")
+	case "seed":
+		s := &Server{cfg: c, db: openDB(c.DatabasePath), log: errorLog}
+		defer s.db.Close()
+		s.runSeed(args)
+	case "adminctl":
+		runAdminctl(c, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nAvailable commands:\n"+
+			"  migrate            apply pending schema migrations and exit (also -migrate-only)\n"+
+			"  sync-legacy-file   regenerate %s from verified subscribers\n"+
+			"  seed               fill an empty database with fake development data\n"+
+			"  adminctl           send a command to the running server's control socket\n", name, legacyEmailFile)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting read once at startup, parsed and validated in one
+// place so a bad or missing value stops the server before it serves
+// anything, with every problem reported at once. Each subsystem's init
+// function takes what it needs from here; nothing reads these variables
+// from the environment afterwards.
+//
+// The settings that SIGHUP can reload (EMAIL_ADDRESS, EMAIL_PASSWORD,
+// DKIM_DOMAIN, AUTO_REPLY_ENABLED) are not here; see runtimeSettings.
+type Config struct {
+	SessionSecret     string
+	UnsubscribeSecret string // UNSUBSCRIBE_SECRET, default SessionSecret
+	CSRFSecret        string // CSRF_SECRET, default derived from SessionSecret
+	AdminToken        string
+
+	FacebookKey, FacebookSecret string
+	GoogleKey, GoogleSecret     string
+	GithubKey, GithubSecret     string
+
+	DatabasePath string // DATABASE_PATH, or the path in DATABASE_URL
+
+	SMTPHost string
+	SMTPPort int
+	SMTPTLS  string
+	SMTPAuth string
+	SMTPFrom *mail.Address // nil: use EMAIL_ADDRESS
+
+	MailTransport       string
+	SimulateLatency     time.Duration
+	SimulateFailureRate float64
+
+	EmailWorkers     int
+	EmailRetryMax    int
+	BroadcastWorkers int
+
+	BaseURL       *url.URL // nil when unset
+	SignSiteLinks bool
+	SiteName      string
+
+	BlockedEmailDomains []string // EMAIL_BLACKLIST_DOMAINS, lowercased
+
+	ControlSocket   string
+	ShutdownTimeout time.Duration
+	RequestTimeout  time.Duration
+	HealthCheckSMTP bool
+
+	ExportDir string
+
+	LegacyEmailFile         bool
+	LegacyEmailFileMaxBytes int64
+
+	RateRPS        float64
+	RateBurst      int
+	RateIdleTTL    time.Duration
+	TrustedProxies []netip.Prefix
+
+	SecurityAlertThreshold int
+	PrivacyLog             bool
+
+	LogFormat string // LOG_FORMAT: text or json
+}
+
+// defaultShutdownTimeout bounds how long open requests and running
+// broadcasts may take to finish after SIGINT or SIGTERM.
+const defaultShutdownTimeout = 30 * time.Second
+
+// LoadConfig reads the configuration from the environment.
+func LoadConfig() (*Config, error) {
+	return configFromEnv(os.Getenv)
+}
+
+func configFromEnv(getenv func(string) string) (*Config, error) {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	intVar := func(key string, def, min int) int {
+		v := getenv(key)
+		if v == "" {
+			return def
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min {
+			if min == 0 {
+				fail("%s must be zero or a positive integer", key)
+			} else {
+				fail("%s must be a positive integer", key)
+			}
+		}
+		return n
+	}
+	durationVar := func(key string, def time.Duration, example string) time.Duration {
+		v := getenv(key)
+		if v == "" {
+			return def
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			fail("%s must be a duration, e.g. %s", key, example)
+		}
+		return d
+	}
+
+	c := &Config{
+		SessionSecret:     getenv("SESSION_SECRET"),
+		UnsubscribeSecret: getenv("UNSUBSCRIBE_SECRET"),
+		CSRFSecret:        getenv("CSRF_SECRET"),
+		AdminToken:        getenv("ADMIN_TOKEN"),
+		FacebookKey:       getenv("FACEBOOK_KEY"),
+		FacebookSecret:    getenv("FACEBOOK_SECRET"),
+		GoogleKey:         getenv("GOOGLE_KEY"),
+		GoogleSecret:      getenv("GOOGLE_SECRET"),
+		GithubKey:         getenv("GITHUB_KEY"),
+		GithubSecret:      getenv("GITHUB_SECRET"),
+		DatabasePath:      getenv("DATABASE_PATH"),
+		SiteName:          getenv("SITE_NAME"),
+		ControlSocket:     getenv("CONTROL_SOCKET"),
+		ExportDir:         getenv("EXPORT_DIR"),
+		LegacyEmailFile:   getenv("LEGACY_EMAIL_FILE") == "1",
+		SignSiteLinks:     getenv("SIGN_SITE_LINKS") == "1",
+		PrivacyLog:        getenv("PRIVACY_LOG") == "1",
+		HealthCheckSMTP:   getenv("HEALTH_CHECK_SMTP") == "1",
+	}
+	if c.SessionSecret == "" {
+		fail("SESSION_SECRET is required")
+	}
+	if c.UnsubscribeSecret == "" {
+		c.UnsubscribeSecret = c.SessionSecret
+	}
+	if c.CSRFSecret == "" {
+		c.CSRFSecret = deriveCSRFSecret(c.SessionSecret)
+	}
+	if v := getenv("DATABASE_URL"); v != "" {
+		path, err := databasePathFromURL(v)
+		switch {
+		case err != nil:
+			fail("%v", err)
+		case c.DatabasePath != "" && c.DatabasePath != path:
+			fail("DATABASE_URL and DATABASE_PATH name different databases; set only one")
+		}
+		c.DatabasePath = path
+	}
+	if c.DatabasePath == "" {
+		c.DatabasePath = defaultDatabasePath
+	}
+	if c.SiteName == "" {
+		c.SiteName = defaultSiteName
+	}
+	if c.ExportDir == "" {
+		c.ExportDir = defaultExportDir
+	}
+
+	// SMTP
+	c.SMTPHost = getenv("SMTP_HOST")
+	if c.SMTPHost == "" {
+		c.SMTPHost = "smtp.gmail.com"
+	}
+	c.SMTPTLS, c.SMTPPort = smtpTLSStart, 587
+	if v := getenv("SMTP_TLS"); v != "" {
+		switch v {
+		case smtpTLSNone:
+			c.SMTPPort = 25
+		case smtpTLSStart:
+		case smtpTLSImplicit:
+			c.SMTPPort = 465
+		default:
+			fail("SMTP_TLS must be one of none, starttls, implicit")
+		}
+		c.SMTPTLS = v
+	}
+	if v := getenv("SMTP_PORT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 65535 {
+			fail("SMTP_PORT must be a port number")
+		}
+		c.SMTPPort = n
+	}
+	c.SMTPAuth = smtpAuthPlain
+	if v := getenv("SMTP_AUTH"); v != "" {
+		if v != smtpAuthPlain && v != smtpAuthCRAM && v != smtpAuthNone {
+			fail("SMTP_AUTH must be one of plain, cram-md5, none")
+		}
+		c.SMTPAuth = v
+	}
+	if v := getenv("SMTP_FROM"); v != "" {
+		addr, err := mail.ParseAddress(v)
+		if err != nil {
+			fail("SMTP_FROM is not a valid address: %v", err)
+		}
+		c.SMTPFrom = addr
+	}
+
+	// Transport
+	c.MailTransport = getenv("MAIL_TRANSPORT")
+	switch c.MailTransport {
+	case "":
+		c.MailTransport = transportSMTP
+	case transportSMTP, transportSimulate:
+	default:
+		fail("MAIL_TRANSPORT must be %q or %q, got %q", transportSMTP, transportSimulate, c.MailTransport)
+	}
+	c.SimulateLatency = durationVar("SIMULATE_LATENCY", defaultSimulateLatency, "200ms")
+	if v := getenv("SIMULATE_FAILURE_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			fail("SIMULATE_FAILURE_RATE must be between 0 and 1")
+		}
+		c.SimulateFailureRate = f
+	}
+
+	// Workers
+	c.EmailWorkers = intVar("EMAIL_WORKERS", defaultEmailWorkers, 1)
+	c.EmailRetryMax = intVar("EMAIL_RETRY_MAX", defaultEmailRetryMax, 0)
+	c.BroadcastWorkers = intVar("BROADCAST_WORKERS", defaultBroadcastWorkers, 1)
+
+	// Links
+	if v := getenv("BASE_URL"); v != "" {
+		u, err := url.Parse(strings.TrimRight(v, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("BASE_URL must be an absolute http(s) URL, e.g. https://example.com")
+		} else {
+			c.BaseURL = u
+		}
+	}
+	if c.SignSiteLinks && c.BaseURL == nil {
+		fail("SIGN_SITE_LINKS=1 needs BASE_URL")
+	}
+
+	// Signups
+	for _, d := range strings.Split(getenv("EMAIL_BLACKLIST_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d == "" {
+			continue
+		}
+		if !validEmailDomain(d) {
+			fail("EMAIL_BLACKLIST_DOMAINS must be a comma-separated list of domains, got %q", d)
+			continue
+		}
+		c.BlockedEmailDomains = append(c.BlockedEmailDomains, d)
+	}
+
+	// Legacy file
+	c.LegacyEmailFileMaxBytes = defaultLegacyMaxBytes
+	if v := getenv("LEGACY_EMAIL_FILE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			fail("LEGACY_EMAIL_FILE_MAX_BYTES must be a positive integer")
+		}
+		c.LegacyEmailFileMaxBytes = n
+	}
+
+	// Rate limiting
+	c.RateRPS = defaultRateRPS
+	if v := getenv("RATE_RPS"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			fail("RATE_RPS must be a positive number")
+		}
+		c.RateRPS = f
+	}
+	c.RateBurst = intVar("RATE_BURST", defaultRateBurst, 1)
+	c.RateIdleTTL = durationVar("RATE_IDLE_TTL", defaultRateIdleTTL, "10m")
+	if c.RateIdleTTL == 0 {
+		fail("RATE_IDLE_TTL must be a positive duration, e.g. 10m")
+	}
+	for _, f := range strings.Split(getenv("TRUSTED_PROXIES"), ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(f)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(f)
+			if addrErr != nil {
+				fail("TRUSTED_PROXIES must be a comma-separated list of IPs or CIDRs, got %q", f)
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		c.TrustedProxies = append(c.TrustedProxies, p.Masked())
+	}
+
+	c.LogFormat = getenv("LOG_FORMAT")
+	switch c.LogFormat {
+	case "":
+		c.LogFormat = logFormatText
+	case logFormatText, logFormatJSON:
+	default:
+		fail("LOG_FORMAT must be %q or %q, got %q", logFormatText, logFormatJSON, c.LogFormat)
+	}
+
+	c.ShutdownTimeout = durationVar("SHUTDOWN_TIMEOUT", defaultShutdownTimeout, "30s")
+	if c.ShutdownTimeout == 0 {
+		fail("SHUTDOWN_TIMEOUT must be a positive duration, e.g. 30s")
+	}
+	c.RequestTimeout = durationVar("REQUEST_TIMEOUT", defaultRequestTimeout, "10s")
+
+	// Security events
+	c.SecurityAlertThreshold = intVar("SECURITY_ALERT_THRESHOLD", defaultSecurityAlertThreshold, 1)
+
+	return c, errors.Join(errs...)
+}
+
+// databasePathFromURL reads DATABASE_URL: a plain path, :memory:, or
+// sqlite:PATH, sqlite://PATH or file:PATH. Postgres URLs are refused with
+// the reason, rather than failing later on the first query, until the
+// deferred Postgres store (see SubscriberStore) exists: every query
+// and migration is written for SQLite, and the email queue, rate limits
+// and broadcasts live in the process, so a second instance would send the
+// same mail twice even against a shared database.
+func databasePathFromURL(v string) (string, error) {
+	scheme, rest, found := strings.Cut(v, ":")
+	if v == ":memory:" || !found || strings.ContainsAny(scheme, "/.") {
+		return v, nil
+	}
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return "", errors.New("DATABASE_URL: Postgres support is deferred; this build only runs on SQLite, as a single instance")
+	case "sqlite", "sqlite3", "file":
+		path := strings.TrimPrefix(rest, "//")
+		if path == "" {
+			return "", errors.New("DATABASE_URL has no database path")
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("DATABASE_URL scheme %q is not supported; use a file path or sqlite:PATH", scheme)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDatabasePathFromURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, path, err string
+	}{
+		{url: "news.db", path: "news.db"},
+		{url: "/var/lib/news/news.db", path: "/var/lib/news/news.db"},
+		{url: ":memory:", path: ":memory:"},
+		{url: "sqlite:news.db", path: "news.db"},
+		{url: "sqlite:///var/lib/news.db", path: "/var/lib/news.db"},
+		{url: "file:news.db", path: "news.db"},
+		{url: "./data:2025/news.db", path: "./data:2025/news.db"},
+		{url: "postgres://news@db/news", err: "Postgres support is deferred"},
+		{url: "PostgreSQL://news@db/news", err: "Postgres support is deferred"},
+		{url: "sqlite:", err: "no database path"},
+		{url: "mysql://news@db/news", err: `scheme "mysql"`},
+	} {
+		path, err := databasePathFromURL(tc.url)
+		switch {
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: err = %v, want %q", tc.url, err, tc.err)
+		case tc.err == "" && (err != nil || path != tc.path):
+			t.Errorf("%q = %q, %v; want %q", tc.url, path, err, tc.path)
+		}
+	}
+}
+
+func TestDatabaseURLAndPath(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string {
+			if k == "SESSION_SECRET" {
+				return "secret"
+			}
+			return vars[k]
+		}
+	}
+
+	c, err := configFromEnv(env(map[string]string{"DATABASE_URL": "sqlite:a.db", "DATABASE_PATH": "a.db"}))
+	if err != nil || c.DatabasePath != "a.db" {
+		t.Errorf("matching DATABASE_URL and DATABASE_PATH = %v, %v", c, err)
+	}
+	if _, err := configFromEnv(env(map[string]string{"DATABASE_URL": "sqlite:a.db", "DATABASE_PATH": "b.db"})); err == nil {
+		t.Error("DATABASE_URL and DATABASE_PATH naming different databases was accepted")
+	}
+	if _, err := configFromEnv(env(map[string]string{"DATABASE_URL": "postgres://news@db/news"})); err == nil {
+		t.Error("a Postgres DATABASE_URL was accepted")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// An optional Unix socket for emergency operations from a shell on the
+// host, e.g. when OAuth or the admin token is broken. Access control is the
+// filesystem: the socket is created 0700, so only its owner (and root) can
+// connect. With CONTROL_SOCKET unset nothing listens.
+//
+// The protocol is one JSON request line and one JSON response per
+// connection; `adminctl` on the binary is the client.
+
+const (
+	controlActor          = "local-socket"
+	controlTimeout        = 10 * time.Second
+	defaultEmergencyTTL   = time.Hour
+	maxEmergencyTokenTTL  = 24 * time.Hour
+	controlMaxRequestSize = 4 << 10
+)
+
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type controlResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+var controlListener net.Listener
+
+func (s *Server) startControlSocket(c *Config) {
+	path := c.ControlSocket
+	if path == "" {
+		return
+	}
+
+	// A socket left behind by a crash is safe to replace; anything else is not
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			log.Fatalf("❌ CONTROL_SOCKET %s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	// Create it without group/other access rather than chmod afterwards
+	oldMask := syscall.Umask(0o077)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		log.Fatalf("❌ Failed to open control socket: %v", err)
+	}
+	if err := os.Chmod(path, 0o700); err != nil {
+		ln.Close()
+		log.Fatalf("❌ Failed to restrict control socket permissions: %v", err)
+	}
+	controlListener = ln
+	log.Println("🔧 Control socket listening on", path)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Println("⚠️ Control socket accept failed:", err)
+				continue
+			}
+			go s.serveControlConn(conn)
+		}
+	}()
+}
+
+// stopControlSocket closes the listener, which also removes the socket file.
+func stopControlSocket() {
+	if controlListener != nil {
+		controlListener.Close()
+	}
+}
+
+func (s *Server) serveControlConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	var resp controlResponse
+	// Cut off at the size limit; a truncated line fails to parse
+	line, _ := bufio.NewReader(io.LimitReader(conn, controlMaxRequestSize)).ReadBytes('\n')
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp.Error = "malformed request"
+	} else {
+		msg, err := s.runControlCommand(req)
+		resp.OK, resp.Message = err == nil, msg
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+
+	log.Printf("🔐 Audit: actor=%s command=%q args=%q ok=%v", controlActor, req.Command, req.Args, resp.OK)
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *Server) runControlCommand(req controlRequest) (string, error) {
+	switch req.Command {
+	case "status":
+		return s.controlStatus()
+
+	case "maintenance":
+		usage := errors.New("usage: maintenance on [window] | off")
+		switch {
+		case len(req.Args) == 1 && req.Args[0] == "off":
+			stopMaintenance()
+			return "Maintenance mode is off", nil
+		case len(req.Args) >= 1 && len(req.Args) <= 2 && req.Args[0] == "on":
+			window := defaultMaintenanceWindow
+			if len(req.Args) == 2 {
+				d, err := time.ParseDuration(req.Args[1])
+				if err != nil || d <= 0 || d > maxMaintenanceWindow {
+					return "", fmt.Errorf("window must be a duration up to %s", maxMaintenanceWindow)
+				}
+				window = d
+			}
+			ends := startMaintenance(window)
+			return "Maintenance mode is on until " + ends.UTC().Format(time.RFC3339), nil
+		}
+		return "", usage
+
+	case "admin-token":
+		ttl := defaultEmergencyTTL
+		if len(req.Args) > 0 {
+			d, err := time.ParseDuration(req.Args[0])
+			if err != nil || d <= 0 || d > maxEmergencyTokenTTL {
+				return "", fmt.Errorf("ttl must be a duration up to %s", maxEmergencyTokenTTL)
+			}
+			ttl = d
+		}
+		token, expires, err := issueEmergencyToken(ttl)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Emergency admin token (valid until %s):\n%s\n\nUse it as: Authorization: Bearer <token>",
+			expires.UTC().Format(time.RFC3339), token), nil
+
+	case "reload-settings":
+		reloadSettings()
+		invalidateDeliverability()
+		return "Settings reloaded from .env; see the server log for what changed", nil
+	}
+	return "", fmt.Errorf("unknown command %q", req.Command)
+}
+
+func (s *Server) controlStatus() (string, error) {
+	counts, err := s.emailQueueCounts(context.Background())
+	if err != nil {
+		return "", err
+	}
+	statuses := make([]string, 0, len(counts))
+	for st := range counts {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+
+	var b strings.Builder
+	mode := "off"
+	if maintenanceMode.Load() {
+		mode = "on, announced until " + time.Unix(0, maintenanceEnds.Load()).UTC().Format(time.RFC3339)
+	}
+	fmt.Fprintf(&b, "Maintenance mode: %s\n", mode)
+	fmt.Fprintf(&b, "Email queue:\n")
+	for _, st := range statuses {
+		fmt.Fprintf(&b, "  %-8s %d\n", st, counts[st])
+	}
+	running := 0
+	runningBroadcasts.Range(func(_, _ any) bool { running++; return true })
+	fmt.Fprintf(&b, "Broadcasts sending: %d", running)
+	return b.String(), nil
+}
+
+// runAdminctl is the `adminctl` subcommand: it sends one command to the
+// control socket of the running server and prints the reply.
+func runAdminctl(c *Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: adminctl <command> [args]\n\nCommands:\n"+
+			"  status                 maintenance mode, email queue and broadcasts\n"+
+			"  maintenance on [window]|off\n"+
+			"                         answer public pages with 503; Retry-After counts\n"+
+			"                         down the window (default 5m, max 24h)\n"+
+			"  admin-token [ttl]      issue an emergency admin token (default 1h, max 24h)\n"+
+			"  reload-settings        re-read .env, as on SIGHUP")
+		os.Exit(2)
+	}
+	path := c.ControlSocket
+	if path == "" {
+		log.Fatal("❌ CONTROL_SOCKET is not set")
+	}
+
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		log.Fatal("❌ Could not reach the server: ", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{Command: args[0], Args: args[1:]}); err != nil {
+		log.Fatal("❌ Could not send command: ", err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		log.Fatal("❌ Could not read reply: ", err)
+	}
+	if !resp.OK {
+		fmt.Fprintln(os.Stderr, "❌", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println(resp.Message)
+}
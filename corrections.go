@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Correction suggestions for typo'd addresses. When the SMTP server
+// permanently refuses a recipient (see hardBounceError) and the address's
+// domain is a near miss of a major provider (gamil.com, hotmial.com,
+// yahooo.com), the corrected address is stored on the subscriber row and
+// listed at GET /admin/corrections. POST /admin/corrections/{id}/accept
+// or /dismiss resolves one; like every admin route they take the bearer
+// token, so the page shows those calls rather than buttons a browser
+// couldn't authenticate. Accepting suppresses the typo'd row and signs the
+// corrected address up again, unverified, with a fresh confirmation email;
+// the subscriber has no other contact channel to ask.
+
+// typoTargets are the domains a typo is corrected to.
+var typoTargets = []string{
+	"gmail.com", "googlemail.com",
+	"yahoo.com", "yahoo.fr",
+	"hotmail.com", "hotmail.fr",
+	"outlook.com", "outlook.fr",
+	"icloud.com",
+	"protonmail.com",
+	"yandex.com",
+}
+
+// knownDomains are real mail domains that sit within typo distance of a
+// target and must never be "corrected".
+var knownDomains = map[string]bool{
+	"mail.com": true, "email.com": true, "ymail.com": true, "rocketmail.com": true,
+	"gmx.com": true, "gmx.net": true, "gmx.de": true,
+	"hotmail.co.uk": true, "hotmail.de": true, "hotmail.it": true, "hotmail.es": true,
+	"outlook.de": true, "outlook.es": true, "outlook.it": true,
+	"live.com": true, "live.fr": true, "live.co.uk": true, "msn.com": true,
+	"yahoo.de": true, "yahoo.es": true, "yahoo.it": true, "yahoo.ca": true,
+	"yahoo.co.uk": true, "yahoo.co.jp": true,
+	"me.com": true, "mac.com": true, "aol.com": true, "aim.com": true,
+	"proton.me": true, "pm.me": true,
+	"yandex.ru": true, "ya.ru": true, "mail.ru": true,
+}
+
+// correctedDomain returns the provider domain that domain is most likely
+// a typo of. Short domains only get one edit, so a distance-2 match there
+// is almost always a different, real domain; a tie between two targets
+// means the intent is unclear and nothing is suggested.
+func correctedDomain(domain string) (string, bool) {
+	domain = strings.ToLower(domain)
+	if knownDomains[domain] {
+		return "", false
+	}
+	best, bestDist, tie := "", 0, false
+	for _, target := range typoTargets {
+		if domain == target {
+			return "", false
+		}
+		limit := 1
+		if len(target) >= 10 {
+			limit = 2
+		}
+		d := editDistance(domain, target)
+		if d > limit {
+			continue
+		}
+		switch {
+		case best == "" || d < bestDist:
+			best, bestDist, tie = target, d, false
+		case d == bestDist:
+			tie = true
+		}
+	}
+	if best == "" || tie {
+		return "", false
+	}
+	return best, true
+}
+
+// editDistance is the Levenshtein distance counting a swap of two adjacent
+// characters as one edit (gamil, hotmial), over bytes: domains are ASCII.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// suggestCorrection stores a corrected address for a subscriber whose mail
+// hard-bounced, if the domain looks like a typo. An earlier suggestion,
+// open or resolved, is kept. Failures are logged, never surfaced.
+func (s *Server) suggestCorrection(subscriberID int, email string) {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return
+	}
+	fixed, ok := correctedDomain(domain)
+	if !ok {
+		return
+	}
+	suggested := local + "@" + fixed
+	res, err := s.db.Exec(`UPDATE subscribers SET suggested_email = ?
+		WHERE id = ? AND email = ? AND suggested_email IS NULL AND unsubscribed_at IS NULL`,
+		suggested, subscriberID, email)
+	if err != nil {
+		log.Println("⚠️ Failed to store correction suggestion:", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("🔧 Hard bounce for %s; suggesting %s", email, suggested)
+	}
+}
+
+type correction struct {
+	SubscriberID   int64  `json:"subscriber_id"`
+	Email          string `json:"email"`
+	SuggestedEmail string `json:"suggested_email"`
+	CreatedAt      string `json:"created_at"`
+}
+
+var correctionSample = []correction{{
+	SubscriberID: 1, Email: "subscriber@gamil.com", SuggestedEmail: "subscriber@gmail.com",
+	CreatedAt: "2025-01-01 00:00:00",
+}}
+
+var correctionsPage = template.Must(template.New("corrections").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Suggested corrections</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; }
+    table { border-collapse: collapse; width: 100%; margin-top: 1rem; }
+    td, th { border-bottom: 1px solid #ddd; padding: 0.25rem 0.5rem; text-align: left; }
+    pre { background: #f4f4f4; padding: 0.5rem; }
+  </style>
+</head>
+<body>
+  <h1>Suggested corrections</h1>
+  <p>Addresses that hard-bounced and look like a typo of a major provider. Accepting suppresses the original and sends a fresh confirmation to the corrected address.</p>
+  <p>Admin requests need the bearer token, so resolve a suggestion from the command line:</p>
+  <pre>curl -X POST -H "Authorization: Bearer $ADMIN_TOKEN" &lt;site&gt;/admin/corrections/&lt;id&gt;/accept
+curl -X POST -H "Authorization: Bearer $ADMIN_TOKEN" &lt;site&gt;/admin/corrections/&lt;id&gt;/dismiss</pre>
+  <table>
+    <tr><th>ID</th><th>Signed up</th><th>Bounced address</th><th>Suggestion</th></tr>
+    {{range .}}<tr><td>{{.SubscriberID}}</td><td>{{.CreatedAt}}</td><td>{{.Email}}</td><td>{{.SuggestedEmail}}</td></tr>
+    {{else}}<tr><td colspan="4">Nothing to review.</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// handleCorrections serves GET /admin/corrections: open suggestions, oldest
+// first, as HTML or JSON for Accept: application/json.
+func (s *Server) handleCorrections(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), `SELECT id, email, suggested_email, created_at FROM subscribers
+		WHERE suggested_email IS NOT NULL AND suggestion_resolved_at IS NULL ORDER BY id`)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to load corrections: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	list := []correction{}
+	for rows.Next() {
+		var c correction
+		if err := rows.Scan(&c.SubscriberID, &c.Email, &c.SuggestedEmail, &c.CreatedAt); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read corrections: "+err.Error())
+			return
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read corrections: "+err.Error())
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := correctionsPage.Execute(w, list); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
+
+// handleAcceptCorrection serves POST /admin/corrections/{id}/accept.
+func (s *Server) handleAcceptCorrection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "❌ Invalid subscriber id")
+		return
+	}
+	var email, suggested string
+	err = s.db.QueryRowContext(r.Context(), `SELECT email, suggested_email FROM subscribers
+		WHERE id = ? AND suggested_email IS NOT NULL AND suggestion_resolved_at IS NULL`, id).
+		Scan(&email, &suggested)
+	if err == sql.ErrNoRows {
+		s.writeError(w, r, http.StatusNotFound, "❌ No open suggestion for this subscriber")
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to load suggestion: "+err.Error())
+		return
+	}
+
+	newID, verified, emailID, err := s.applyCorrection(r.Context(), id, email, suggested)
+	if errors.Is(err, errEmailQueueFull) {
+		// Nothing was changed, so the retry finds the suggestion still open
+		s.writeRetryLater(w, r, http.StatusServiceUnavailable, emailQueueRetryAfter(), "⚠️ The email queue is full; nothing was changed, please try again shortly")
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Could not apply correction: "+err.Error())
+		return
+	}
+	log.Printf("🔧 Correction accepted by %s: %s → %s", adminActor(r), email, suggested)
+
+	payload := map[string]any{"status": "corrected", "email": suggested, "subscriber_id": newID}
+	if verified {
+		respond(w, r, http.StatusOK, "✅ "+suggested+" was already subscribed; "+email+" is suppressed", payload)
+		return
+	}
+	dispatchQueuedEmail(emailID)
+	s.recordFunnelEvent(r.Context(), newID, stageConfirmationSent)
+	payload["status"] = "verification_sent"
+	respond(w, r, http.StatusAccepted, "✅ Confirmation sent to "+suggested, payload)
+}
+
+// applyCorrection suppresses the typo'd row, cancels its queued mail and
+// signs the corrected address up, reopening it if it had unsubscribed. It
+// reports whether the corrected address is already verified; if not, a
+// verification link and its confirmation email are queued in the same
+// transaction, and the returned email id is for dispatchQueuedEmail. With
+// the queue full it fails with errEmailQueueFull and changes nothing.
+func (s *Server) applyCorrection(ctx context.Context, id int, email, suggested string) (newID int, verified bool, emailID int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	defer tx.Rollback()
+	defer func() {
+		if err != nil && emailID != 0 {
+			// Queued, but the commit failed
+			releaseEmailSlot()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `UPDATE subscribers SET suggestion_resolved_at = CURRENT_TIMESTAMP,
+		unsubscribed_at = COALESCE(unsubscribed_at, CURRENT_TIMESTAMP), verification_token = NULL WHERE id = ?`, id)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE pending_emails SET status = ?, last_error = ? WHERE subscriber_id = ? AND status = ?",
+		emailFailed, "address corrected to "+suggested, id, emailPending)
+	if err != nil {
+		return 0, false, 0, err
+	}
+
+	if _, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO subscribers(email, created_at) VALUES(?, CURRENT_TIMESTAMP)", suggested); err != nil {
+		return 0, false, 0, err
+	}
+	var unsubscribed bool
+	err = tx.QueryRowContext(ctx, "SELECT id, verified, unsubscribed_at IS NOT NULL FROM subscribers WHERE email = ?", suggested).
+		Scan(&newID, &verified, &unsubscribed)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	if unsubscribed {
+		_, err = tx.ExecContext(ctx, "UPDATE subscribers SET verified = 0, verified_at = NULL, unsubscribed_at = NULL WHERE id = ?", newID)
+		if err != nil {
+			return 0, false, 0, err
+		}
+		verified = false
+	}
+
+	if !verified {
+		var token string
+		if token, err = issueVerificationToken(ctx, tx, newID); err != nil {
+			return 0, false, 0, fmt.Errorf("create verification link: %w", err)
+		}
+		data := confirmationData{Recipient: suggested, VerifyLink: verificationLink(token), SiteName: siteName}
+		if emailID, err = queueConfirmationEmail(ctx, tx, newID, "confirmation", data); err != nil {
+			return 0, false, 0, err
+		}
+	}
+	err = tx.Commit()
+	return newID, verified, emailID, err
+}
+
+// handleDismissCorrection serves POST /admin/corrections/{id}/dismiss.
+func (s *Server) handleDismissCorrection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "❌ Invalid subscriber id")
+		return
+	}
+	res, err := s.db.ExecContext(r.Context(), `UPDATE subscribers SET suggestion_resolved_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND suggested_email IS NOT NULL AND suggestion_resolved_at IS NULL`, id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Could not dismiss suggestion: "+err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.writeError(w, r, http.StatusNotFound, "❌ No open suggestion for this subscriber")
+		return
+	}
+	log.Printf("🔧 Correction for subscriber %d dismissed by %s", id, adminActor(r))
+	respond(w, r, http.StatusOK, "✅ Suggestion dismissed", map[string]string{"status": "dismissed"})
+}
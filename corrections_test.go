@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCorrectionsPage(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	sub, err := s.store.AddSubscriber(t.Context(), "reader@gmial.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.suggestCorrection(sub.ID, sub.Email)
+
+	resp, page := do(t, ts, http.MethodGet, "/admin/corrections", nil, "Authorization", "Bearer "+testAdminToken)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(page, "reader@gmail.com") {
+		t.Fatalf("GET /admin/corrections = %d %q", resp.StatusCode, page)
+	}
+	// A browser form could send neither the bearer token nor a CSRF token
+	if strings.Contains(page, "<form") {
+		t.Error("the page has a form that can't authenticate")
+	}
+	if !strings.Contains(page, "/admin/corrections/&lt;id&gt;/accept") {
+		t.Error("the page doesn't show the accept call")
+	}
+}
+
+func TestAcceptCorrectionWithQueueFull(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	sub, err := s.store.AddSubscriber(t.Context(), "reader@gmial.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.suggestCorrection(sub.ID, sub.Email)
+	accept := "/admin/corrections/" + strconv.Itoa(sub.ID) + "/accept"
+	auth := []string{"Authorization", "Bearer " + testAdminToken, "Accept", "application/json"}
+
+	release := takeEmailSlots(t, emailQueueCapacity)
+	resp, body := do(t, ts, http.MethodPost, accept, nil, auth...)
+	release()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("accept with the queue full = %d %q, want 503 with Retry-After", resp.StatusCode, body)
+	}
+	if got, _ := s.store.GetByEmail(t.Context(), "reader@gmail.com"); got.ID != 0 {
+		t.Error("the refused accept signed up the corrected address")
+	}
+	if got, _ := s.store.GetByEmail(t.Context(), sub.Email); got.Unsubscribed {
+		t.Error("the refused accept suppressed the original address")
+	}
+
+	// The retry the 503 asked for finds the suggestion still open
+	resp, body = do(t, ts, http.MethodPost, accept, nil, auth...)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("retried accept = %d %q, want 202", resp.StatusCode, body)
+	}
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM pending_emails WHERE recipient = ?", "reader@gmail.com").Scan(&n)
+	if n != 1 {
+		t.Errorf("%d confirmations for the corrected address, want 1", n)
+	}
+	if resp, _ := do(t, ts, http.MethodPost, accept, nil, auth...); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("accepting twice = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestCorrectedDomain(t *testing.T) {
+	for _, tc := range []struct {
+		domain, want string
+	}{
+		// Typos
+		{"gmial.com", "gmail.com"},
+		{"hotmial.com", "hotmail.com"},
+		{"gmail.con", "gmail.com"},
+		{"GMAIL.CON", "gmail.com"},
+		{"yahooo.fr", "yahoo.fr"},
+		// Real domains close to a target
+		{"gmx.com", ""},
+		{"mail.com", ""},
+		{"hotmail.de", ""},
+		{"gmail.com", ""},
+		// Short targets allow one edit, targets of 10 or more bytes two
+		{"gmaill.con", ""},
+		{"protonmial.cm", "protonmail.com"},
+		{"protonmial.c", ""},
+		{"example.org", ""},
+	} {
+		got, ok := correctedDomain(tc.domain)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("correctedDomain(%q) = %q, %v, want %q", tc.domain, got, ok, tc.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"gmail.com", "gmail.com", 0},
+		{"", "gmx", 3},
+		{"gmial.com", "gmail.com", 1},  // adjacent swap
+		{"gmail.con", "gmail.com", 1},  // substitution
+		{"gmai.com", "gmail.com", 1},   // deletion
+		{"gmaill.con", "gmail.com", 2}, // one over the short limit
+		{"protonmial.cm", "protonmail.com", 2},
+		{"protonmial.c", "protonmail.com", 3}, // one over the long limit
+		{"kitten", "sitting", 3},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := editDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read-only DNS checks for the From domain (SPF / DMARC) so misconfigured
+// sending setups are spotted before mail starts landing in spam.
+
+const (
+	dnsLookupTimeout  = 5 * time.Second
+	deliverabilityTTL = 15 * time.Minute
+	maxSPFLookups     = 10 // RFC 7208 limit on DNS-querying mechanisms
+	spfPrefix         = "v=spf1"
+)
+
+// Known SPF includes for common SMTP relays, used when the include domain
+// does not share a name with the SMTP host itself.
+var providerSPFIncludes = map[string]string{
+	"smtp.gmail.com":       "_spf.google.com",
+	"smtp.office365.com":   "spf.protection.outlook.com",
+	"smtp.mailgun.org":     "mailgun.org",
+	"smtp.sendgrid.net":    "sendgrid.net",
+	"smtp-relay.gmail.com": "_spf.google.com",
+}
+
+type deliverabilityReport struct {
+	FromDomain  string    `json:"from_domain"`
+	SMTPHost    string    `json:"smtp_host"`
+	DKIMDomain  string    `json:"dkim_domain,omitempty"`
+	SPFRecord   string    `json:"spf_record,omitempty"`
+	DMARCRecord string    `json:"dmarc_record,omitempty"`
+	DMARCPolicy string    `json:"dmarc_policy,omitempty"`
+	Problems    []string  `json:"problems"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+func (r *deliverabilityReport) OK() bool { return len(r.Problems) == 0 }
+
+var deliverabilityCache struct {
+	sync.Mutex
+	report *deliverabilityReport
+}
+
+// currentDeliverability returns the cached report, re-running the DNS checks
+// when the cache is stale or a refresh is forced.
+func (s *Server) currentDeliverability(ctx context.Context, refresh bool) *deliverabilityReport {
+	deliverabilityCache.Lock()
+	defer deliverabilityCache.Unlock()
+
+	if !refresh && deliverabilityCache.report != nil &&
+		time.Since(deliverabilityCache.report.CheckedAt) < deliverabilityTTL {
+		return deliverabilityCache.report
+	}
+
+	cfg := currentSettings()
+	report := checkDeliverability(ctx, s.senderAddress(cfg), s.smtp.host, cfg.DKIMDomain)
+	deliverabilityCache.report = report
+	return report
+}
+
+// invalidateDeliverability drops the cached report, e.g. after the sender changed.
+func invalidateDeliverability() {
+	deliverabilityCache.Lock()
+	deliverabilityCache.report = nil
+	deliverabilityCache.Unlock()
+}
+
+func checkDeliverability(ctx context.Context, from, host, dkimDomain string) *deliverabilityReport {
+	report := &deliverabilityReport{
+		SMTPHost:   host,
+		DKIMDomain: strings.ToLower(dkimDomain),
+		Problems:   []string{},
+		CheckedAt:  time.Now(),
+	}
+
+	at := strings.LastIndex(from, "@")
+	if at < 0 || at == len(from)-1 {
+		report.Problems = append(report.Problems, "EMAIL_ADDRESS is not set to a valid sender address")
+		return report
+	}
+	domain := strings.ToLower(from[at+1:])
+	report.FromDomain = domain
+
+	// SPF
+	spf, err := lookupTXTPrefix(ctx, domain, spfPrefix)
+	switch {
+	case err != nil:
+		report.Problems = append(report.Problems, "SPF lookup for "+domain+" failed: "+err.Error())
+	case spf == "":
+		report.Problems = append(report.Problems, "No SPF record found for "+domain+"; receivers cannot authorize "+host)
+	default:
+		report.SPFRecord = spf
+		lookups := 0
+		if !spfAuthorizes(ctx, spf, host, &lookups, 0) {
+			report.Problems = append(report.Problems, "SPF record does not include "+host)
+		}
+	}
+
+	// DMARC
+	dmarc, err := lookupTXTPrefix(ctx, "_dmarc."+domain, "v=DMARC1")
+	switch {
+	case err != nil:
+		report.Problems = append(report.Problems, "DMARC lookup for "+domain+" failed: "+err.Error())
+	case dmarc == "":
+		report.Problems = append(report.Problems, "No DMARC record found at _dmarc."+domain)
+	default:
+		report.DMARCRecord = dmarc
+		report.DMARCPolicy = dmarcTag(dmarc, "p")
+		strict := report.DMARCPolicy == "reject" || report.DMARCPolicy == "quarantine"
+		if strict && report.DKIMDomain != "" && !domainAligned(report.DKIMDomain, domain) {
+			report.Problems = append(report.Problems,
+				"DMARC policy is "+report.DMARCPolicy+" but DKIM domain differs ("+report.DKIMDomain+" vs "+domain+")")
+		}
+	}
+
+	return report
+}
+
+// lookupTXTPrefix returns the first TXT record on name starting with prefix,
+// or "" when the name exists but has no such record.
+func lookupTXTPrefix(ctx context.Context, name, prefix string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, rec := range records {
+		if strings.HasPrefix(strings.ToLower(rec), strings.ToLower(prefix)) {
+			return rec, nil
+		}
+	}
+	return "", nil
+}
+
+// spfAuthorizes walks include: and redirect= mechanisms (bounded by the RFC
+// lookup limit) looking for one that covers the SMTP host.
+func spfAuthorizes(ctx context.Context, record, host string, lookups *int, depth int) bool {
+	hostBase := baseDomain(host)
+	known := providerSPFIncludes[host]
+
+	for _, term := range strings.Fields(record)[1:] {
+		term = strings.TrimLeft(strings.ToLower(term), "+~?")
+		var target string
+		switch {
+		case strings.HasPrefix(term, "include:"):
+			target = strings.TrimPrefix(term, "include:")
+		case strings.HasPrefix(term, "redirect="):
+			target = strings.TrimPrefix(term, "redirect=")
+		case strings.HasPrefix(term, "a:"), strings.HasPrefix(term, "mx:"):
+			if baseDomain(term[strings.Index(term, ":")+1:]) == hostBase {
+				return true
+			}
+			continue
+		default:
+			continue
+		}
+
+		if target == known || baseDomain(target) == hostBase {
+			return true
+		}
+		if depth >= 3 || *lookups >= maxSPFLookups {
+			continue
+		}
+		*lookups++
+		nested, err := lookupTXTPrefix(ctx, target, spfPrefix)
+		if err == nil && nested != "" && spfAuthorizes(ctx, nested, host, lookups, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+func dmarcTag(record, tag string) string {
+	for _, part := range strings.Split(record, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), tag) {
+			return strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return ""
+}
+
+// domainAligned implements DMARC relaxed alignment: same organizational domain.
+func domainAligned(a, b string) bool {
+	return baseDomain(a) == baseDomain(b)
+}
+
+// baseDomain is a naive organizational-domain approximation (last two labels).
+func baseDomain(host string) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// logDeliverability runs the check once at startup and logs any problems.
+func (s *Server) logDeliverability() {
+	report := s.currentDeliverability(context.Background(), true)
+	if report.OK() {
+		log.Println("✅ Sender domain SPF/DMARC look aligned for", report.FromDomain)
+		return
+	}
+	for _, p := range report.Problems {
+		log.Println("⚠️ Deliverability:", p)
+	}
+}
+
+func (s *Server) handleDeliverability(w http.ResponseWriter, r *http.Request) {
+	report := s.currentDeliverability(r.Context(), r.URL.Query().Get("refresh") != "")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
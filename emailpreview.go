@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// Fixed sample data so raw previews are byte-for-byte reproducible.
+const (
+	previewRecipient = "subscriber@example.com"
+	previewSender    = "newsletter@example.com"
+	previewLink      = "http://localhost:8080/verify?token=sample-verification-token"
+	previewUnsubLink = "http://localhost:8080/unsubscribe?token=sample-unsubscribe-token"
+	previewSiteURL   = "https://example.com"
+	previewMessageID = "<preview@example.com>"
+)
+
+// previewBroadcast documents the broadcast placeholders by using each one;
+// %[1]s is the site base URL.
+const previewBroadcast = `Placeholders available in broadcast bodies:
+
+  {{"{{.PrefsURL}}"}}         {{.PrefsURL}}
+  {{"{{.ArchiveURL}}"}}       {{.ArchiveURL}}
+  {{"{{.Token \"purpose\"}}"}}  {{.Token "purpose"}}
+
+With SIGN_SITE_LINKS=1, other links into BASE_URL get sub= too:
+  %[1]s/events
+Links to other sites are left alone:
+  https://other.example/`
+
+// Fixed clock for previews, so Date and signed tokens don't change between runs.
+var previewTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// previewEmail renders a named template with sample data into raw MIME.
+func (s *Server) previewEmail(name, lang string) ([]byte, bool, error) {
+	from := s.fromHeader(currentSettings())
+	if from == "" {
+		from = previewSender
+	}
+
+	switch name {
+	case "confirmation":
+		subject, text, html, err := renderEmail(name, emailTemplateSamples[name])
+		if err != nil {
+			return nil, true, err
+		}
+		text, headers := withUnsubscribe(text, nil, previewUnsubLink)
+		html = withUnsubscribeHTML(html, previewUnsubLink)
+		return buildMessage(from, previewRecipient, subject, text, html, previewTime, previewMessageID, headers), true, nil
+	case "auto_reply":
+		subject, body, err := loadAutoReplyTemplate(lang)
+		if err != nil {
+			return nil, true, err
+		}
+		body, headers := withUnsubscribe(body, autoReplyHeaders, previewUnsubLink)
+		return buildMessage(from, previewRecipient, subject, body, "", previewTime, previewMessageID, headers), true, nil
+	case "broadcast":
+		base := siteBaseURL
+		if base == nil {
+			base, _ = url.Parse(previewSiteURL)
+		}
+		tmpl := template.Must(template.New("broadcast").Funcs(i18nFuncs).Parse(fmt.Sprintf(previewBroadcast, base)))
+		body, err := renderCampaign(tmpl, campaignData{subscriberID: 1, now: previewTime, base: base}, true)
+		if err != nil {
+			return nil, true, err
+		}
+		body, headers := withUnsubscribe(body, nil, previewUnsubLink)
+		return buildMessage(from, previewRecipient, "Broadcast preview", body, "", previewTime, previewMessageID, headers), true, nil
+	}
+	return nil, false, nil
+}
+
+// handleRawEmailPreview serves GET /admin/email-templates/{name}/raw?lang=
+// with the exact bytes that would be handed to SMTP, for external preview
+// services.
+func (s *Server) handleRawEmailPreview(w http.ResponseWriter, r *http.Request) {
+	lang := requestLang(r.URL.Query().Get("lang"))
+	msg, found, err := s.previewEmail(r.PathValue("name"), lang)
+	if !found {
+		http.Error(w, "Unknown email template", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "❌ Could not render template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `inline; filename="`+r.PathValue("name")+"."+lang+`.eml"`)
+	w.Write(msg)
+}
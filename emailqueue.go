@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outgoing mail goes through pending_emails so a slow or failing SMTP server
+// never holds up a request, and a restart never loses a queued message.
+//
+// A message takes one of emailQueueCapacity slots and its row is handed to
+// a pool of EMAIL_WORKERS goroutines; with every slot taken takeEmailSlot
+// refuses with errEmailQueueFull, so the caller can answer 503 instead of
+// waiting, with a Retry-After from emailQueueRetryAfter. A failed send is
+// rescheduled with exponential backoff, up to EMAIL_RETRY_MAX retries, and
+// a dispatcher feeds due retries back to the pool. The backoff lives in
+// next_attempt_at rather than in a sleeping worker, so a bad address never
+// ties up a worker and its retries survive a restart.
+
+const (
+	emailPending = "pending"
+	emailSending = "sending"
+	emailSent    = "sent"
+	emailFailed  = "failed"
+
+	emailKindConfirmation = "confirmation"
+
+	defaultEmailWorkers  = 3
+	defaultEmailRetryMax = 4
+	emailQueueCapacity   = 100
+	emailRetryBase       = 30 * time.Second
+	emailRetryMaxDelay   = 6 * time.Hour
+	emailQueuePoll       = 30 * time.Second
+	// Bounds, in seconds, of the Retry-After sent when the queue is full
+	minEmailQueueRetryAfter = 5
+	maxEmailQueueRetryAfter = 600
+	// Assumed time for one send until a send has been timed
+	defaultEmailSendTime = time.Second
+)
+
+var errEmailQueueFull = errors.New("email queue is full")
+
+var emailQueue struct {
+	workers  int
+	retryMax int
+
+	slots chan struct{} // one per queued or in-flight message
+	jobs  chan int64    // claimed pending_emails ids
+	stop  chan struct{}
+
+	// closed is set under the write lock before jobs is closed, so no
+	// enqueue can send on a closed channel.
+	mu     sync.RWMutex
+	closed bool
+
+	dispatcher sync.WaitGroup
+	pool       sync.WaitGroup
+
+	sendTime atomic.Int64 // moving average of one send, in nanoseconds
+}
+
+// Queueing takes three steps, so the message is stored in the caller's own
+// transaction: take a slot first, insert through the transaction, and
+// dispatch once it has committed (or release the slot if it didn't).
+
+func takeEmailSlot() error {
+	emailQueue.mu.RLock()
+	defer emailQueue.mu.RUnlock()
+	if emailQueue.closed {
+		return errEmailQueueFull
+	}
+	select {
+	case emailQueue.slots <- struct{}{}:
+		return nil
+	default:
+		return errEmailQueueFull
+	}
+}
+
+func releaseEmailSlot() {
+	<-emailQueue.slots
+}
+
+// emailQueueRetryAfter estimates, in whole seconds, how long the workers
+// need to work through the messages queued now, which is when a client
+// refused with errEmailQueueFull can expect room again.
+func emailQueueRetryAfter() int {
+	per := time.Duration(emailQueue.sendTime.Load())
+	if per <= 0 {
+		per = defaultEmailSendTime
+	}
+	drain := per * time.Duration(len(emailQueue.slots)) / time.Duration(max(emailQueue.workers, 1))
+	return min(max(int(math.Ceil(drain.Seconds())), minEmailQueueRetryAfter), maxEmailQueueRetryAfter)
+}
+
+// recordEmailSendTime folds one send into the moving average, weighting
+// it 1/8. Concurrent workers may drop a sample, which an estimate can bear.
+func recordEmailSendTime(d time.Duration) {
+	avg := time.Duration(emailQueue.sendTime.Load())
+	if avg > 0 {
+		d = avg + (d-avg)/8
+	}
+	emailQueue.sendTime.Store(int64(d))
+}
+
+// insertPendingEmail stores the row as already claimed ("sending"), so the
+// dispatcher leaves it to dispatchQueuedEmail.
+func insertPendingEmail(ctx context.Context, q dbtx, kind string, subscriberID int, to, subject, body, html string, headers map[string]string) (int64, error) {
+	var headerJSON []byte
+	if len(headers) > 0 {
+		headerJSON, _ = json.Marshal(headers)
+	}
+	var id int64
+	err := q.QueryRowContext(ctx, `INSERT INTO pending_emails(kind, subscriber_id, recipient, subject, body, html_body, headers, status, next_attempt_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		kind, sql.NullInt64{Int64: int64(subscriberID), Valid: subscriberID != 0}, to, subject, body,
+		sql.NullString{String: html, Valid: html != ""},
+		sql.NullString{String: string(headerJSON), Valid: headerJSON != nil}, emailSending, sqliteTime(time.Now())).Scan(&id)
+	return id, err
+}
+
+// dispatchQueuedEmail hands a stored row to the pool. Once shutdown has
+// begun the row stays "sending" and the next start requeues it.
+func dispatchQueuedEmail(id int64) {
+	emailQueue.mu.RLock()
+	defer emailQueue.mu.RUnlock()
+	if emailQueue.closed {
+		releaseEmailSlot()
+		return
+	}
+	// Never blocks: jobs holds as many ids as there are slots
+	emailQueue.jobs <- id
+}
+
+// startEmailQueue starts EMAIL_WORKERS workers and the dispatcher. Rows left "sending" by a crash are put back in
+// the queue first; a duplicate is better than a lost email.
+func (s *Server) startEmailQueue(c *Config) {
+	emailQueue.workers, emailQueue.retryMax = c.EmailWorkers, c.EmailRetryMax
+
+	res, err := s.db.Exec("UPDATE pending_emails SET status = ? WHERE status = ?", emailPending, emailSending)
+	if err != nil {
+		log.Fatalf("❌ Failed to recover email queue: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("⚠️ Requeued %d emails interrupted by the last shutdown", n)
+	}
+
+	emailQueue.slots = make(chan struct{}, emailQueueCapacity)
+	emailQueue.jobs = make(chan int64, emailQueueCapacity)
+	emailQueue.stop = make(chan struct{})
+	emailQueue.closed = false // a test may start a second Server after stopping one
+	for range emailQueue.workers {
+		emailQueue.pool.Add(1)
+		go s.runEmailWorker()
+	}
+	emailQueue.dispatcher.Add(1)
+	go s.runEmailDispatcher()
+}
+
+// stopEmailQueue stops taking new mail and waits until the workers have
+// sent everything already handed to them, or until ctx is done. Retries
+// scheduled for later, and sends abandoned at the deadline, stay in the
+// table for the next start. Stopping twice does nothing.
+func stopEmailQueue(ctx context.Context) {
+	emailQueue.mu.Lock()
+	if emailQueue.stop == nil || emailQueue.closed {
+		emailQueue.mu.Unlock()
+		return
+	}
+	emailQueue.closed = true
+	emailQueue.mu.Unlock()
+	close(emailQueue.stop)
+	emailQueue.dispatcher.Wait()
+	close(emailQueue.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		emailQueue.pool.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("⚠️ Abandoned %d emails at the shutdown deadline; they will be sent after the next start", len(emailQueue.slots))
+	}
+}
+
+// runEmailDispatcher moves due retries and recovered rows into the pool.
+func (s *Server) runEmailDispatcher() {
+	defer emailQueue.dispatcher.Done()
+	for {
+		for s.dispatchDueEmail() {
+		}
+		select {
+		case <-emailQueue.stop:
+			return
+		case <-time.After(emailQueuePoll):
+		}
+	}
+}
+
+// dispatchDueEmail waits for a free slot, then claims one due row. It
+// reports whether it found one.
+func (s *Server) dispatchDueEmail() bool {
+	select {
+	case emailQueue.slots <- struct{}{}:
+	case <-emailQueue.stop:
+		return false
+	}
+
+	var id int64
+	err := s.db.QueryRow(`
+		UPDATE pending_emails SET status = ?
+		WHERE id = (SELECT id FROM pending_emails WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT 1)
+		RETURNING id`,
+		emailSending, emailPending, sqliteTime(time.Now())).Scan(&id)
+	if err != nil {
+		<-emailQueue.slots
+		if err != sql.ErrNoRows {
+			s.logError(context.Background(), componentStore, "⚠️ Email queue: could not claim a message", err)
+		}
+		return false
+	}
+	emailQueue.jobs <- id
+	return true
+}
+
+func (s *Server) runEmailWorker() {
+	defer emailQueue.pool.Done()
+	// A worker abandoned at shutdown may finish after the next start has
+	// made new channels; it must free its slot in the old one.
+	jobs, slots := emailQueue.jobs, emailQueue.slots
+	for id := range jobs {
+		s.deliverQueuedEmail(id)
+		<-slots
+	}
+}
+
+type queuedEmail struct {
+	ID           int64
+	Kind         string
+	SubscriberID sql.NullInt64
+	To           string
+	Subject      string
+	Body         string
+	HTML         string
+	Headers      map[string]string
+	Attempts     int
+}
+
+// deliverQueuedEmail sends one claimed row and records the outcome.
+func (s *Server) deliverQueuedEmail(id int64) {
+	e := queuedEmail{ID: id}
+	var html, headerJSON sql.NullString
+	err := s.db.QueryRow(`SELECT kind, subscriber_id, recipient, subject, body, html_body, headers, attempts
+		FROM pending_emails WHERE id = ?`, id).
+		Scan(&e.Kind, &e.SubscriberID, &e.To, &e.Subject, &e.Body, &html, &headerJSON, &e.Attempts)
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Email queue: could not load message", err, "email_id", id)
+		return
+	}
+	e.HTML = html.String
+	if headerJSON.Valid {
+		json.Unmarshal([]byte(headerJSON.String), &e.Headers)
+	}
+
+	started := time.Now()
+	sendErr := s.sendMessage(e.To, e.Subject, e.Body, e.HTML, e.Headers)
+	recordEmailSendTime(time.Since(started))
+	e.Attempts++
+	if sendErr == nil {
+		_, err = s.db.Exec("UPDATE pending_emails SET status = ?, attempts = ?, sent_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = ?",
+			emailSent, e.Attempts, e.ID)
+		if err != nil {
+			s.logError(context.Background(), componentStore, "⚠️ Email queue: could not mark message sent", err, "email_id", e.ID)
+		}
+		s.emailDelivered(e)
+		return
+	}
+
+	var bounce *hardBounceError
+	if errors.As(sendErr, &bounce) && e.SubscriberID.Valid {
+		s.suggestCorrection(int(e.SubscriberID.Int64), e.To)
+	}
+
+	delay := min(emailRetryBase<<min(e.Attempts-1, 20), emailRetryMaxDelay)
+	status, next := emailPending, time.Now().Add(delay)
+	if e.Attempts > emailQueue.retryMax {
+		status = emailFailed
+		s.logError(context.Background(), componentMail, "❌ Giving up on email", sendErr, "email_id", e.ID, "to", e.To, "attempts", e.Attempts)
+	}
+	_, err = s.db.Exec("UPDATE pending_emails SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		status, e.Attempts, sqliteTime(next), sendErr.Error(), e.ID)
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Email queue: could not reschedule message", err, "email_id", e.ID)
+	}
+}
+
+// emailDelivered runs the per-kind bookkeeping after a successful send.
+func (s *Server) emailDelivered(e queuedEmail) {
+	switch e.Kind {
+	case emailKindConfirmation:
+		s.recordFunnelEvent(context.Background(), int(e.SubscriberID.Int64), stageDelivered)
+		log.Println("✅ Confirmation email sent to:", e.To)
+	}
+}
+
+// emailQueueCounts returns the number of messages in each status.
+func (s *Server) emailQueueCounts(ctx context.Context) (map[string]int, error) {
+	counts := map[string]int{emailPending: 0, emailSending: 0, emailSent: 0, emailFailed: 0}
+	rows, err := s.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM pending_emails GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+type emailQueueFailure struct {
+	ID        int64  `json:"id"`
+	Kind      string `json:"kind"`
+	Recipient string `json:"recipient"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+type emailQueueReport struct {
+	Workers        int                 `json:"workers"`
+	Capacity       int                 `json:"capacity"`
+	InFlight       int                 `json:"in_flight"`
+	Counts         map[string]int      `json:"counts"`
+	OldestPending  *string             `json:"oldest_pending"`
+	RecentFailures []emailQueueFailure `json:"recent_failures"`
+}
+
+// handleEmailQueue serves GET /admin/email-queue: pool usage, counts per
+// status, the oldest waiting message and the latest permanent failures.
+func (s *Server) handleEmailQueue(w http.ResponseWriter, r *http.Request) {
+	report := emailQueueReport{
+		Workers:        emailQueue.workers,
+		Capacity:       emailQueueCapacity,
+		InFlight:       len(emailQueue.slots),
+		RecentFailures: []emailQueueFailure{},
+	}
+
+	counts, err := s.emailQueueCounts(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read email queue"})
+		return
+	}
+	report.Counts = counts
+
+	var oldest sql.NullString
+	err = s.db.QueryRowContext(r.Context(), "SELECT MIN(created_at) FROM pending_emails WHERE status IN (?, ?)", emailPending, emailSending).Scan(&oldest)
+	if err == nil && oldest.Valid {
+		report.OldestPending = &oldest.String
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `SELECT id, kind, recipient, attempts, COALESCE(last_error, '')
+		FROM pending_emails WHERE status = ? ORDER BY id DESC LIMIT 20`, emailFailed)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read email queue"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f emailQueueFailure
+		if err := rows.Scan(&f.ID, &f.Kind, &f.Recipient, &f.Attempts, &f.LastError); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read email queue"})
+			return
+		}
+		report.RecentFailures = append(report.RecentFailures, f)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
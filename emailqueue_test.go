@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// takeEmailSlots holds n slots, and returns once no one else holds any:
+// the dispatcher's scan takes a slot for a moment even when nothing is due.
+// release gives them back; the test's end does too if it wasn't called.
+func takeEmailSlots(t *testing.T, n int) (release func()) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	taken := 0
+	for taken < n || len(emailQueue.slots) != taken {
+		if time.Now().After(deadline) {
+			t.Fatalf("took %d of %d email slots, %d in use", taken, n, len(emailQueue.slots))
+		}
+		if taken < n && takeEmailSlot() == nil {
+			taken++
+			continue
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			for range taken {
+				releaseEmailSlot()
+			}
+		})
+	}
+	t.Cleanup(release)
+	return release
+}
+
+// fillEmailQueue takes every slot until the test ends.
+func fillEmailQueue(t *testing.T) {
+	t.Helper()
+	takeEmailSlots(t, emailQueueCapacity)
+}
+
+func TestEmailQueueFullAnswers503(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"EMAIL_WORKERS": "4"})
+	fillEmailQueue(t)
+	emailQueue.sendTime.Store(int64(800 * time.Millisecond))
+
+	resp, body := do(t, ts, http.MethodPost, "/subscriber/email", map[string]string{"email": "reader@example.com"})
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("signup with the queue full = %d %q, want 503", resp.StatusCode, body)
+	}
+	// 100 queued messages at 0.8s each over 4 workers
+	if got := resp.Header.Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %q, want 20", got)
+	}
+	var out struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil || out.RetryAfterSeconds != 20 || out.Error == "" {
+		t.Errorf("body = %q, want an error and retry_after_seconds 20", body)
+	}
+	if n := countRows(t, s.db, "subscribers"); n != 0 {
+		t.Errorf("a refused signup left %d subscriber rows", n)
+	}
+}
+
+func TestEmailQueueRetryAfter(t *testing.T) {
+	newTestServer(t, map[string]string{"EMAIL_WORKERS": "3"})
+	t.Cleanup(func() { emailQueue.sendTime.Store(0) })
+
+	for _, tc := range []struct {
+		queued   int
+		sendTime time.Duration
+		want     int
+	}{
+		{0, time.Second, minEmailQueueRetryAfter},
+		{30, 0, 10},                                 // untimed, a send counts as defaultEmailSendTime
+		{60, 2 * time.Second, 40},                   // 60 messages, 2s each, 3 workers
+		{100, 1100 * time.Millisecond, 37},          // 36.67s rounds up
+		{10, 100 * time.Millisecond, 5},             // a fast queue still asks for the minimum
+		{100, time.Minute, maxEmailQueueRetryAfter}, // a stuck SMTP server is capped
+	} {
+		release := takeEmailSlots(t, tc.queued)
+		emailQueue.sendTime.Store(int64(tc.sendTime))
+		got := emailQueueRetryAfter()
+		release()
+		if got != tc.want {
+			t.Errorf("%d queued at %s per send = %d, want %d", tc.queued, tc.sendTime, got, tc.want)
+		}
+	}
+}
+
+func TestRecordEmailSendTime(t *testing.T) {
+	t.Cleanup(func() { emailQueue.sendTime.Store(0) })
+	emailQueue.sendTime.Store(0)
+
+	recordEmailSendTime(time.Second)
+	if got := time.Duration(emailQueue.sendTime.Load()); got != time.Second {
+		t.Errorf("first sample = %s, want it taken as is", got)
+	}
+	recordEmailSendTime(9 * time.Second)
+	if got := time.Duration(emailQueue.sendTime.Load()); got != 2*time.Second {
+		t.Errorf("after a 9s send = %s, want 2s", got)
+	}
+}
+
+func TestShutdownAbandonsSlowSends(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"SIMULATE_LATENCY": "1s"})
+	subscribe(t, ts, "reader@example.com")
+	// The abandoned worker reads package state that the next test's
+	// NewServer resets, so let it finish before the test ends
+	t.Cleanup(emailQueue.pool.Wait)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	stopEmailQueue(ctx)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("stopEmailQueue took %s with a 100ms deadline", elapsed)
+	}
+	var status string
+	s.db.QueryRow("SELECT status FROM pending_emails").Scan(&status)
+	if status != emailSending {
+		t.Errorf("abandoned email is %q, want it left %q for the next start", status, emailSending)
+	}
+}
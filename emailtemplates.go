@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Transactional emails live in templates/email as a pair per name:
+// <name>.txt (text/template, "Subject: ..." on the first line, then a blank
+// line and the plain-text body) and <name>.html (html/template). They are
+// parsed at startup and test-rendered by checkTemplates, so a missing or
+// broken template stops the server instead of failing a send.
+
+const emailTemplateDir = "templates/email"
+
+const defaultSiteName = "MyIdy"
+
+// confirmationData is what confirmation.txt and confirmation.html see.
+type confirmationData struct {
+	Recipient  string
+	VerifyLink string
+	SiteName   string
+}
+
+// Sample data for each template, used for the startup check and previews.
+// Fill in every field, so the check sees everything the templates can use.
+var emailTemplateSamples = map[string]any{
+	"confirmation": confirmationData{
+		Recipient:  previewRecipient,
+		VerifyLink: previewLink,
+		SiteName:   defaultSiteName,
+	},
+}
+
+type emailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+var emailTemplates = map[string]emailTemplate{}
+
+// siteName is SITE_NAME, the name emails sign with.
+var siteName = defaultSiteName
+
+func loadEmailTemplates(c *Config) {
+	siteName = c.SiteName
+	for name := range emailTemplateSamples {
+		var t emailTemplate
+		var err error
+		t.text, err = template.New(name + ".txt").Funcs(i18nFuncs).Option("missingkey=error").ParseFiles(filepath.Join(emailTemplateDir, name+".txt"))
+		if err != nil {
+			log.Fatalf("❌ Email template %s.txt: %v", name, err)
+		}
+		t.html, err = htmltemplate.New(name + ".html").Funcs(i18nFuncs).Option("missingkey=error").ParseFiles(filepath.Join(emailTemplateDir, name+".html"))
+		if err != nil {
+			log.Fatalf("❌ Email template %s.html: %v", name, err)
+		}
+		emailTemplates[name] = t
+	}
+}
+
+// renderEmail expands a template pair into a subject and both bodies.
+func renderEmail(name string, data any) (subject, text, html string, err error) {
+	t, ok := emailTemplates[name]
+	if !ok {
+		return "", "", "", fmt.Errorf("email template %s is not loaded", name)
+	}
+
+	var b strings.Builder
+	if err := t.text.Execute(&b, data); err != nil {
+		return "", "", "", err
+	}
+	header, body, found := strings.Cut(b.String(), "\n\n")
+	subject, hasSubject := strings.CutPrefix(header, "Subject: ")
+	if !found || !hasSubject || strings.Contains(subject, "\n") {
+		return "", "", "", fmt.Errorf(`email template %s.txt must start with "Subject: ..." and a blank line`, name)
+	}
+
+	b.Reset()
+	if err := t.html.Execute(&b, data); err != nil {
+		return "", "", "", err
+	}
+	return strings.TrimSpace(subject), strings.TrimRight(body, "\n"), b.String(), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recent errors per component, for answering "what was the last mail
+// error?" without tailing logs. errorLog is a slog.Logger whose handler
+// writes through the standard log output as usual and also keeps every
+// Error-level record in a small in-memory ring per component, served at
+// GET /admin/errors. Nothing is persisted; a restart clears the rings.
+//
+// Identical errors (same component, message and error text) collapse into
+// one entry with a count, so a failing SMTP server can't push everything
+// else out of the ring.
+
+const (
+	componentMail  = "mail"
+	componentStore = "store"
+	componentOAuth = "oauth"
+	componentHTTP  = "http"
+
+	errorRingSize = 50
+	// Per component, for the last-hour count
+	maxErrorHits = 1000
+)
+
+var errorLog = slog.New(&errorRingHandler{inner: slog.Default().Handler()})
+
+// logError records err under component; ctx carries the request id, if any.
+func (s *Server) logError(ctx context.Context, component, msg string, err error, args ...any) {
+	args = append([]any{"component", component}, args...)
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	s.log.ErrorContext(ctx, msg, args...)
+}
+
+type errorEntry struct {
+	Message   string            `json:"message"`
+	Error     string            `json:"error,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Count     int               `json:"count"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+}
+
+type errorRing struct {
+	entries []*errorEntry // oldest first
+	hits    []time.Time   // one per record, oldest first
+}
+
+var errorRings = struct {
+	sync.Mutex
+	byComponent map[string]*errorRing
+}{byComponent: make(map[string]*errorRing)}
+
+func recordRingError(component string, e errorEntry) {
+	errorRings.Lock()
+	defer errorRings.Unlock()
+	ring := errorRings.byComponent[component]
+	if ring == nil {
+		ring = &errorRing{}
+		errorRings.byComponent[component] = ring
+	}
+
+	ring.hits = append(ring.hits, e.LastSeen)
+	if len(ring.hits) > maxErrorHits {
+		ring.hits = ring.hits[len(ring.hits)-maxErrorHits:]
+	}
+
+	// A repeat moves to the newest position with its latest details
+	key := e.Message + "\x00" + e.Error
+	for i, old := range ring.entries {
+		if old.Message+"\x00"+old.Error == key {
+			e.Count, e.FirstSeen = old.Count+1, old.FirstSeen
+			ring.entries = append(ring.entries[:i], ring.entries[i+1:]...)
+			break
+		}
+	}
+	ring.entries = append(ring.entries, &e)
+	if len(ring.entries) > errorRingSize {
+		ring.entries = ring.entries[1:]
+	}
+}
+
+// errorRingHandler tees Error-level records into errorRings.
+type errorRingHandler struct {
+	inner slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *errorRingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.inner.Enabled(ctx, level)
+}
+
+func (h *errorRingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		component := "other"
+		e := errorEntry{Message: redactSecrets(r.Message), Count: 1, FirstSeen: r.Time, LastSeen: r.Time}
+		e.RequestID, _ = ctx.Value(requestIDKey{}).(string)
+		add := func(a slog.Attr) bool {
+			v := redactSecrets(a.Value.String())
+			if isSensitiveKey(a.Key) {
+				v = "[redacted]"
+			}
+			switch a.Key {
+			case "component":
+				component = v
+			case "error":
+				e.Error = v
+			default:
+				if e.Attrs == nil {
+					e.Attrs = make(map[string]string)
+				}
+				e.Attrs[a.Key] = v
+			}
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		r.Attrs(add)
+		recordRingError(component, e)
+	}
+	if !h.inner.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *errorRingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorRingHandler{inner: h.inner.WithAttrs(attrs), attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *errorRingHandler) WithGroup(name string) slog.Handler {
+	return &errorRingHandler{inner: h.inner.WithGroup(name), attrs: h.attrs}
+}
+
+// isSensitiveKey reports whether an env var or log attribute name holds a
+// credential.
+func isSensitiveKey(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range []string{"SECRET", "PASSWORD", "TOKEN", "KEY"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces the value of every sensitive env var found in s.
+func redactSecrets(s string) string {
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		// Short values would match ordinary words
+		if len(v) >= 6 && isSensitiveKey(k) {
+			s = strings.ReplaceAll(s, v, "[redacted]")
+		}
+	}
+	return s
+}
+
+type errorComponentReport struct {
+	Component string        `json:"component"`
+	LastHour  int           `json:"last_hour"`
+	Errors    []*errorEntry `json:"errors"` // newest first
+}
+
+// handleErrors serves GET /admin/errors: every component's ring, newest
+// first, with the number of errors logged in the last hour.
+func handleErrors(w http.ResponseWriter, r *http.Request) {
+	errorRings.Lock()
+	defer errorRings.Unlock()
+
+	since := time.Now().Add(-time.Hour)
+	report := []errorComponentReport{}
+	for component, ring := range errorRings.byComponent {
+		c := errorComponentReport{Component: component}
+		for _, t := range ring.hits {
+			if t.After(since) {
+				c.LastHour++
+			}
+		}
+		for i := len(ring.entries) - 1; i >= 0; i-- {
+			e := *ring.entries[i]
+			c.Errors = append(c.Errors, &e)
+		}
+		report = append(report, c)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Component < report[j].Component })
+	writeJSON(w, http.StatusOK, report)
+}
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an id, taken from a well-formed
+// X-Request-ID header (e.g. set by a proxy) or generated, and echoes it in
+// the response so a user-reported failure can be found in /admin/errors.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
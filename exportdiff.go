@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Membership delta between two instants. A member is a verified subscriber
+// who hasn't unsubscribed. Joins come from "verified" funnel events; rows
+// verified before those events existed fall back to verified_at/created_at
+// and are flagged approximate. Leaves come from subscribers.unsubscribed_at.
+
+const (
+	diffReasonVerified     = "verified"
+	diffReasonUnsubscribed = "unsubscribed"
+)
+
+type diffEntry struct {
+	Email       string `json:"email"`
+	Change      string `json:"change"` // added or removed
+	Reason      string `json:"reason"`
+	At          string `json:"at"`
+	Approximate bool   `json:"approximate"`
+}
+
+// joinedAt is the time a verified subscriber joined, as SQL over subscribers s.
+const joinedAtSQL = `COALESCE(
+	(SELECT MAX(e.created_at) FROM funnel_events e WHERE e.subscriber_id = s.id AND e.stage = 'verified'),
+	s.verified_at, s.created_at)`
+
+const exportDiffQuery = `
+	SELECT s.email, 'added', 'verified', MAX(e.created_at) AS at, 0
+	FROM funnel_events e
+	JOIN subscribers s ON s.id = e.subscriber_id
+	WHERE e.stage = 'verified' AND e.created_at > ?1 AND e.created_at <= ?2
+		AND s.verified = 1 AND (s.unsubscribed_at IS NULL OR s.unsubscribed_at > ?2)
+		AND NOT EXISTS (SELECT 1 FROM funnel_events p
+			WHERE p.subscriber_id = s.id AND p.stage = 'verified' AND p.created_at <= ?1)
+	GROUP BY s.id
+
+	UNION ALL
+
+	SELECT s.email, 'added', 'verified', COALESCE(s.verified_at, s.created_at) AS at, 1
+	FROM subscribers s
+	WHERE s.verified = 1 AND COALESCE(s.verified_at, s.created_at) > ?1 AND COALESCE(s.verified_at, s.created_at) <= ?2
+		AND (s.unsubscribed_at IS NULL OR s.unsubscribed_at > ?2)
+		AND NOT EXISTS (SELECT 1 FROM funnel_events e WHERE e.subscriber_id = s.id AND e.stage = 'verified')
+
+	UNION ALL
+
+	SELECT s.email, 'removed', 'unsubscribed', s.unsubscribed_at AS at,
+		NOT EXISTS (SELECT 1 FROM funnel_events e WHERE e.subscriber_id = s.id AND e.stage = 'verified')
+	FROM subscribers s
+	WHERE s.unsubscribed_at > ?1 AND s.unsubscribed_at <= ?2 AND s.verified = 1
+		AND ` + joinedAtSQL + ` <= ?1
+
+	ORDER BY at, 1`
+
+// parseDiffTime accepts RFC 3339 or YYYY-MM-DD (midnight UTC).
+func parseDiffTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(funnelDateLayout, v)
+}
+
+type diffParams struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	CSV  bool      `json:"csv"`
+}
+
+// handleExportDiff serves GET /admin/export/diff?from=&to=[&format=csv][&async=1]:
+// addresses that joined or left the list in (from, to]. Up to
+// exportSyncMaxRows rows stream back directly; a larger diff, or any with
+// async=1, becomes a background job (see jobs.go) and gets 202.
+func (s *Server) handleExportDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	from, err := parseDiffTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from, expected RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		if to, err = parseDiffTime(v); err != nil {
+			http.Error(w, "Invalid to, expected RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	p := diffParams{From: from, To: to, CSV: q.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv")}
+
+	var total int64
+	err = s.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM ("+exportDiffQuery+")", sqliteTime(from), sqliteTime(to)).Scan(&total)
+	if err != nil {
+		http.Error(w, "❌ Failed to compute diff: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if total > exportSyncMaxRows || q.Get("async") == "1" {
+		format := "json"
+		if p.CSV {
+			format = "csv"
+		}
+		s.startExportJob(w, r, jobKindDiff, p, format, total, func(ctx context.Context, w io.Writer, progress func(int64)) error {
+			return s.writeDiff(ctx, w, p, progress)
+		})
+		return
+	}
+
+	if p.CSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="subscriber-diff.csv"`)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := s.writeDiff(r.Context(), w, p, nil); err != nil {
+		// Headers are gone by now; all we can do is stop and log
+		log.Println("⚠️ Export diff aborted:", err)
+	}
+}
+
+// writeDiff writes the diff as CSV or a JSON document, row by row as they
+// are read. progress, if set, is called with the running row count.
+func (s *Server) writeDiff(ctx context.Context, w io.Writer, p diffParams, progress func(rows int64)) error {
+	rows, err := s.db.QueryContext(ctx, exportDiffQuery, sqliteTime(p.From), sqliteTime(p.To))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var cw *csv.Writer
+	if p.CSV {
+		cw = csv.NewWriter(w)
+		cw.Write([]string{"email", "change", "reason", "at", "approximate"})
+	} else {
+		fromJSON, _ := json.Marshal(p.From.UTC())
+		toJSON, _ := json.Marshal(p.To.UTC())
+		if _, err := io.WriteString(w, `{"from":`+string(fromJSON)+`,"to":`+string(toJSON)+`,"changes":[`); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	var count int64
+	for rows.Next() {
+		var e diffEntry
+		if err := rows.Scan(&e.Email, &e.Change, &e.Reason, &e.At, &e.Approximate); err != nil {
+			return err
+		}
+		if t, err := time.Parse("2006-01-02 15:04:05", e.At); err == nil {
+			e.At = t.Format(time.RFC3339)
+		}
+
+		if p.CSV {
+			cw.Write([]string{e.Email, e.Change, e.Reason, e.At, strconv.FormatBool(e.Approximate)})
+		} else {
+			if count > 0 {
+				io.WriteString(w, ",")
+			}
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		count++
+		if progress != nil {
+			progress(count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if p.CSV {
+		cw.Flush()
+		return cw.Error()
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Shared extraction of user-submitted form values. Every handler that reads
+// free-form input goes through formValue so oversized bodies, NUL bytes and
+// invalid UTF-8 never reach the database or later JSON encoding.
+
+const (
+	maxFormBytes  = 64 << 10
+	maxEmailRunes = 254
+	// RFC 5321 limits, in octets
+	maxEmailBytes      = 254
+	maxEmailLocalBytes = 64
+	maxMessageRunes    = 5000
+)
+
+// formError is a validation failure on a single field.
+type formError struct {
+	Field   string
+	Problem string
+}
+
+func (e *formError) Error() string { return e.Field + " " + e.Problem }
+
+var errFormTooLarge = errors.New("request body is too large")
+
+// parseLimitedForm parses the request form with a hard cap on body size.
+// A JSON object body is accepted too and lands in r.PostForm, so formValue
+// reads both the same way.
+func parseLimitedForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if isJSONRequest(r) {
+		return parseJSONForm(r)
+	}
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errFormTooLarge
+		}
+		return &formError{Field: "form", Problem: "could not be parsed"}
+	}
+	return nil
+}
+
+func parseJSONForm(r *http.Request) error {
+	var fields map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errFormTooLarge
+		}
+		return &formError{Field: "body", Problem: "is not a valid JSON object"}
+	}
+	if fields == nil {
+		// null decodes into a nil map without an error
+		return &formError{Field: "body", Problem: "is not a valid JSON object"}
+	}
+
+	form := make(url.Values, len(fields))
+	for k, v := range fields {
+		s, ok := v.(string)
+		if !ok {
+			return &formError{Field: k, Problem: "must be a string"}
+		}
+		form.Set(k, s)
+	}
+	r.PostForm = form
+	r.Form = r.URL.Query()
+	for k, v := range form {
+		r.Form[k] = append(v, r.Form[k]...)
+	}
+	return nil
+}
+
+// formValue returns a cleaned, length-checked field. multiline keeps tabs and
+// newlines; every other C0 control character is removed.
+func formValue(r *http.Request, field string, maxRunes int, required, multiline bool) (string, error) {
+	v := cleanText(r.PostFormValue(field), multiline)
+	if v == "" && required {
+		return "", &formError{Field: field, Problem: "is required"}
+	}
+	if utf8.RuneCountInString(v) > maxRunes {
+		return "", &formError{Field: field, Problem: "is too long"}
+	}
+	return v, nil
+}
+
+// emailValue reads a required address field, checks it with validateEmail
+// and returns it normalized.
+func emailValue(r *http.Request, field string) (string, error) {
+	v, err := formValue(r, field, maxEmailRunes, true, false)
+	if err != nil {
+		return "", err
+	}
+	if err := validateEmail(v); err != nil {
+		return "", &formError{Field: field, Problem: err.Error()}
+	}
+	return normalizeEmail(v), nil
+}
+
+// validateEmail checks a bare address (no display name, no quoted local
+// part, no IP literal); surrounding whitespace is ignored. The error text
+// describes the problem and reads after the field name.
+func validateEmail(s string) error {
+	v := strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(v)
+	if err != nil || addr.Name != "" || addr.Address != v {
+		return errors.New("must be a plain email address like name@example.com")
+	}
+
+	at := strings.LastIndexByte(v, '@')
+	if len(v[:at]) > maxEmailLocalBytes {
+		return errors.New("has a name part longer than 64 bytes")
+	}
+	if len(v) > maxEmailBytes {
+		return errors.New("is too long")
+	}
+	if !validEmailDomain(strings.ToLower(v[at+1:])) {
+		return errors.New("must have a domain like example.com")
+	}
+	return nil
+}
+
+// normalizeEmail returns an address that passed validateEmail in the form
+// it is stored in: trimmed, with the domain lowercased. In the local part
+// only ASCII A-Z are lowercased; every other byte, including UTF-8, is
+// stored exactly as typed.
+func normalizeEmail(s string) string {
+	v := strings.TrimSpace(s)
+	at := strings.LastIndexByte(v, '@')
+	local := strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, v[:at])
+	return local + "@" + strings.ToLower(v[at+1:])
+}
+
+// emailDomainBlocked reports whether a normalized address is at a domain in
+// EMAIL_BLACKLIST_DOMAINS, or a subdomain of one.
+func emailDomainBlocked(email string, blocked []string) bool {
+	domain := email[strings.LastIndexByte(email, '@')+1:]
+	for _, b := range blocked {
+		if domain == b || strings.HasSuffix(domain, "."+b) {
+			return true
+		}
+	}
+	return false
+}
+
+// validEmailDomain wants at least two dot-separated labels of letters (any
+// script), digits and inner hyphens, and a top-level label that isn't all
+// digits.
+func validEmailDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	return strings.TrimFunc(tld, unicode.IsDigit) != ""
+}
+
+func cleanText(v string, multiline bool) string {
+	v = strings.ToValidUTF8(v, "�")
+	v = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			if multiline && (r == '\n' || r == '\t') {
+				return r
+			}
+			if multiline && r == '\r' {
+				return -1 // normalize CRLF to LF
+			}
+			if !multiline && (r == '\n' || r == '\t' || r == '\r') {
+				return ' '
+			}
+			return -1
+		}
+		return r
+	}, v)
+	return strings.TrimSpace(v)
+}
+
+// writeFormError renders a validation failure with the matching status code.
+func (s *Server) writeFormError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errFormTooLarge) {
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, "Request is too large")
+		return
+	}
+	var fe *formError
+	if errors.As(err, &fe) {
+		msg := fe.Error()
+		s.writeError(w, r, http.StatusBadRequest, strings.ToUpper(msg[:1])+msg[1:])
+		return
+	}
+	s.writeError(w, r, http.StatusBadRequest, "Invalid form submission")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// fuzzRequest posts body as a form, or as JSON when asJSON is set.
+func fuzzRequest(path, body string, asJSON bool) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if asJSON {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+func FuzzFormValue(f *testing.F) {
+	f.Add("name=Amira", false, false)
+	f.Add("name=%E2%80%AE%00%0D%0Aline%09two", true, false)
+	f.Add("name=%FF%FE", false, false)
+	f.Add(`{"name":"  padded\u0000 "}`, false, true)
+	f.Add(`{"name":7}`, false, true)
+	f.Add("name="+strings.Repeat("ب", 200), true, false)
+
+	f.Fuzz(func(t *testing.T, body string, multiline, asJSON bool) {
+		r := fuzzRequest("/", body, asJSON)
+		if err := parseLimitedForm(httptest.NewRecorder(), r); err != nil {
+			return
+		}
+		v, err := formValue(r, "name", 100, false, multiline)
+		if err != nil {
+			return
+		}
+		if !utf8.ValidString(v) {
+			t.Errorf("%q is not valid UTF-8", v)
+		}
+		if n := utf8.RuneCountInString(v); n > 100 {
+			t.Errorf("%d runes passed a 100 rune limit", n)
+		}
+		if v != strings.TrimSpace(v) {
+			t.Errorf("%q is not trimmed", v)
+		}
+		for _, c := range v {
+			if (c < 0x20 || c == 0x7f) && !(multiline && (c == '\n' || c == '\t')) {
+				t.Errorf("%q keeps control character %U", v, c)
+			}
+		}
+	})
+}
+
+// FuzzSubscribe posts arbitrary bodies to the signup handler. JSON goes
+// through the whole middleware chain; forms go to the handler itself, since
+// without a CSRF token the chain would stop them first.
+func FuzzSubscribe(f *testing.F) {
+	s, _ := newTestServer(f, map[string]string{"RATE_RPS": "1000000", "RATE_BURST": "1000000"})
+	routes := s.Routes()
+
+	f.Add("email=reader%40example.com", false)
+	f.Add("email=Reader%40EXAMPLE.com&email=other%40example.com", false)
+	f.Add("email=%22quoted%22%40example.com", false)
+	f.Add("email=a%40b", false)
+	f.Add("email=%00%40example.com", false)
+	f.Add(`{"email":"reader@example.com"}`, true)
+	f.Add(`{"email":["reader@example.com"]}`, true)
+	f.Add(`{"email":"`+strings.Repeat("a", 65)+`@example.com"}`, true)
+	f.Add(`[`, true)
+
+	f.Fuzz(func(t *testing.T, body string, asJSON bool) {
+		r := fuzzRequest("/subscriber/email", body, asJSON)
+		w := httptest.NewRecorder()
+		if asJSON {
+			routes.ServeHTTP(w, r)
+		} else {
+			s.handleEmailSubscription(w, r)
+		}
+		switch {
+		case w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "":
+			// the email queue is full; a real answer, not a failure
+		case w.Code >= 500:
+			t.Errorf("%q answered %d: %s", body, w.Code, w.Body)
+		}
+	})
+}
+
+func TestValidateEmail(t *testing.T) {
+	longLocal := strings.Repeat("a", maxEmailLocalBytes)
+	// 63 + 1 + 63 + 1 + 57 + 4 = 189 bytes, so longLocal@longDomain is 254
+	longDomain := strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 57) + ".com"
+
+	for _, tc := range []struct {
+		email, problem string
+	}{
+		{email: "reader@example.com"},
+		{email: "  Reader@Example.COM\t"},
+		{email: "first.last+news@mail.example.co.uk"},
+		{email: "qarie@مثال.شبكة"},
+		{email: longLocal + "@example.com"},
+		{email: strings.Repeat("é", 32) + "@example.com"}, // 64 bytes
+		{email: longLocal + "@" + longDomain},
+		{email: longLocal + "a@example.com", problem: "has a name part longer than 64 bytes"},
+		{email: strings.Repeat("é", 33) + "@example.com", problem: "has a name part longer than 64 bytes"},
+		{email: longLocal + "@c" + longDomain, problem: "is too long"},
+		{email: "", problem: "must be a plain email address like name@example.com"},
+		{email: "reader", problem: "must be a plain email address like name@example.com"},
+		{email: "@example.com", problem: "must be a plain email address like name@example.com"},
+		{email: "reader@", problem: "must be a plain email address like name@example.com"},
+		{email: "Reader <reader@example.com>", problem: "must be a plain email address like name@example.com"},
+		{email: `"quoted"@example.com`, problem: "must be a plain email address like name@example.com"},
+		{email: "a@b@example.com", problem: "must be a plain email address like name@example.com"},
+		{email: "reader@[192.0.2.1]", problem: "must have a domain like example.com"},
+		{email: "reader@localhost", problem: "must have a domain like example.com"},
+		{email: "reader@example.123", problem: "must have a domain like example.com"},
+		{email: "reader@-example.com", problem: "must have a domain like example.com"},
+		{email: "reader@example..com", problem: "must be a plain email address like name@example.com"},
+		{email: "reader@exa_mple.com", problem: "must have a domain like example.com"},
+		{email: "reader@" + strings.Repeat("a", 64) + ".com", problem: "must have a domain like example.com"},
+	} {
+		err := validateEmail(tc.email)
+		switch {
+		case tc.problem == "" && err != nil:
+			t.Errorf("validateEmail(%q) = %v, want it accepted", tc.email, err)
+		case tc.problem != "" && (err == nil || err.Error() != tc.problem):
+			t.Errorf("validateEmail(%q) = %v, want %q", tc.email, err, tc.problem)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"reader@example.com":       "reader@example.com",
+		"  Reader@Example.COM\t":   "reader@example.com",
+		"ÉLÈVE.Dupont@Example.fr":  "ÉlÈve.dupont@example.fr", // only ASCII letters in the local part
+		"Qarie@مثال.شبكة":          "qarie@مثال.شبكة",
+		"Straße@BÜCHER.Example.de": "straße@bücher.example.de",
+	} {
+		if err := validateEmail(in); err != nil {
+			t.Errorf("validateEmail(%q) = %v", in, err)
+		}
+		if got := normalizeEmail(in); got != want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseJSONForm(t *testing.T) {
+	parse := func(body string, asJSON bool) (*http.Request, error) {
+		r := fuzzRequest("/submit?source=query", body, asJSON)
+		return r, parseLimitedForm(httptest.NewRecorder(), r)
+	}
+
+	// Both content types fill PostForm and Form the same way
+	form, err := parse("email=reader%40example.com&message=Salam", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := parse(`{"email": "reader@example.com", "message": "Salam"}`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"email", "message"} {
+		if got, want := js.PostFormValue(field), form.PostFormValue(field); got != want || got == "" {
+			t.Errorf("JSON %s = %q, form %s = %q", field, got, field, want)
+		}
+		if got, want := js.FormValue(field), form.FormValue(field); got != want {
+			t.Errorf("JSON Form %s = %q, form Form %s = %q", field, got, field, want)
+		}
+	}
+	if js.FormValue("source") != "query" || js.PostFormValue("source") != "" {
+		t.Errorf("query string: Form %q, PostForm %q", js.FormValue("source"), js.PostFormValue("source"))
+	}
+
+	for body, want := range map[string]*formError{
+		`{"email": "reader@example.com"`: {Field: "body", Problem: "is not a valid JSON object"},
+		`email=reader@example.com`:       {Field: "body", Problem: "is not a valid JSON object"},
+		`["reader@example.com"]`:         {Field: "body", Problem: "is not a valid JSON object"},
+		`"reader@example.com"`:           {Field: "body", Problem: "is not a valid JSON object"},
+		`null`:                           {Field: "body", Problem: "is not a valid JSON object"},
+		``:                               {Field: "body", Problem: "is not a valid JSON object"},
+		`{"email": 42}`:                  {Field: "email", Problem: "must be a string"},
+		`{"email": ["a@example.com"]}`:   {Field: "email", Problem: "must be a string"},
+		`{"email": null}`:                {Field: "email", Problem: "must be a string"},
+		`{"consent": true}`:              {Field: "consent", Problem: "must be a string"},
+	} {
+		_, err := parse(body, true)
+		var fe *formError
+		if !errors.As(err, &fe) || *fe != *want {
+			t.Errorf("JSON body %q: err = %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestJSONBodyTooLarge(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	resp, body := do(t, ts, http.MethodPost, "/subscriber/email",
+		map[string]string{"email": "reader@example.com", "padding": strings.Repeat("x", maxFormBytes)})
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized JSON body = %d %q, want 413", resp.StatusCode, body)
+	}
+	if n := len(listSubscribers(t, ts)); n != 0 {
+		t.Errorf("an oversized body saved %d subscribers", n)
+	}
+}
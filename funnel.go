@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Verification funnel: form view → submit → confirmation sent → delivered →
+// link clicked → verified. Every stage after the form view is attributed to
+// the subscriber's signup cohort (subscribers.created_at), not the event date.
+
+const (
+	stageFormView         = "form_view"
+	stageSubmitted        = "submitted"
+	stageConfirmationSent = "confirmation_sent"
+	stageDelivered        = "delivered"
+	stageLinkClicked      = "link_clicked"
+	stageVerified         = "verified"
+)
+
+var funnelStages = []string{
+	stageFormView,
+	stageSubmitted,
+	stageConfirmationSent,
+	stageDelivered,
+	stageLinkClicked,
+	stageVerified,
+}
+
+const funnelDateLayout = "2006-01-02"
+
+// recordFunnelEvent is best-effort: a failed insert must never break the
+// user-facing request.
+func (s *Server) recordFunnelEvent(ctx context.Context, subscriberID int, stage string) {
+	if subscriberID == 0 {
+		return
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO funnel_events(subscriber_id, stage) VALUES(?, ?)", subscriberID, stage)
+	if err != nil {
+		log.Println("⚠️ Failed to record funnel event:", stage, err)
+	}
+}
+
+func (s *Server) recordFormView(ctx context.Context) {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO form_views(day, count) VALUES(date('now'), 1)
+		ON CONFLICT(day) DO UPDATE SET count = count + 1`)
+	if err != nil {
+		log.Println("⚠️ Failed to record form view:", err)
+	}
+}
+
+type funnelStage struct {
+	Stage string `json:"stage"`
+	Count int    `json:"count"`
+	Label string `json:"label"`
+	// Conversion from the previous stage and from the first stage, in percent
+	StepRate    float64 `json:"step_rate"`
+	OverallRate float64 `json:"overall_rate"`
+}
+
+type funnelReport struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Stages []funnelStage `json:"stages"`
+}
+
+// handleFunnel serves GET /admin/funnel?from=YYYY-MM-DD&to=YYYY-MM-DD&lang=ar
+// (inclusive, defaulting to the last 30 days).
+func (s *Server) handleFunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(funnelDateLayout, v); err != nil {
+			http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(funnelDateLayout, v); err != nil {
+			http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.computeFunnel(r.Context(), from, to, requestLang(r.URL.Query().Get("lang")))
+	if err != nil {
+		http.Error(w, "❌ Failed to compute funnel: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (s *Server) computeFunnel(ctx context.Context, from, to time.Time, lang string) (*funnelReport, error) {
+	start := from.Format(funnelDateLayout)
+	end := to.AddDate(0, 0, 1).Format(funnelDateLayout) // exclusive upper bound
+
+	counts := make(map[string]int, len(funnelStages))
+
+	var views int
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(count), 0) FROM form_views WHERE day >= ? AND day < ?",
+		start, end).Scan(&views)
+	if err != nil {
+		return nil, err
+	}
+	counts[stageFormView] = views
+
+	// One subscriber counts once per stage, within the cohort that signed up in range
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.stage, COUNT(DISTINCT e.subscriber_id)
+		FROM funnel_events e
+		JOIN subscribers s ON s.id = e.subscriber_id
+		WHERE s.created_at >= ? AND s.created_at < ?
+		GROUP BY e.stage`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stage string
+		var n int
+		if err := rows.Scan(&stage, &n); err != nil {
+			return nil, err
+		}
+		counts[stage] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &funnelReport{
+		From: from.Format(funnelDateLayout),
+		To:   to.Format(funnelDateLayout),
+	}
+	first := counts[funnelStages[0]]
+	prev := first
+	for i, stage := range funnelStages {
+		st := funnelStage{Stage: stage, Count: counts[stage], Label: plural(lang, counts[stage], "subscribers")}
+		if stage == stageFormView {
+			st.Label = plural(lang, st.Count, "form_views")
+		}
+		if i == 0 {
+			st.StepRate, st.OverallRate = 100, 100
+		} else {
+			st.StepRate = percent(st.Count, prev)
+			st.OverallRate = percent(st.Count, first)
+		}
+		report.Stages = append(report.Stages, st)
+		prev = st.Count
+	}
+	return report, nil
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(int(float64(n)*10000/float64(of))) / 100
+}
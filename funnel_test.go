@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// seedFunnel signs up subscribers at the given times and records the
+// stages each one reached, all event rows dated inside January 2025.
+func seedFunnel(t *testing.T, s *Server, signups map[string][]string) {
+	t.Helper()
+	for createdAt, stages := range signups {
+		var id int
+		err := s.db.QueryRow("INSERT INTO subscribers(email, created_at) VALUES('signup' || (SELECT COUNT(*) FROM subscribers) || '@example.com', ?) RETURNING id",
+			createdAt).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stage := range stages {
+			if _, err := s.db.Exec("INSERT INTO funnel_events(subscriber_id, stage, created_at) VALUES(?, ?, '2025-01-15 12:00:00')", id, stage); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+var fullFunnel = []string{stageSubmitted, stageConfirmationSent, stageDelivered, stageLinkClicked, stageVerified}
+
+func TestFunnelCohortsAndRates(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	seedFunnel(t, s, map[string][]string{
+		// In the January cohort. One subscriber clicks twice and verifies
+		// twice, and counts once per stage.
+		"2025-01-10 09:00:00": append(fullFunnel, stageLinkClicked, stageVerified),
+		"2025-01-10 09:01:00": fullFunnel[:3],
+		"2025-01-10 09:02:00": fullFunnel[:2],
+		"2025-01-10 09:03:00": fullFunnel[:1],
+		"2025-01-31 23:59:59": fullFunnel[:1], // the last day is included
+		// Signed up outside the range: their January events belong to
+		// their own cohorts
+		"2024-12-31 23:59:59": fullFunnel,
+		"2025-02-01 00:00:00": fullFunnel,
+	})
+	for day, n := range map[string]int{"2025-01-05": 8, "2025-01-20": 2, "2024-12-31": 100, "2025-02-01": 50} {
+		s.db.Exec("INSERT INTO form_views(day, count) VALUES(?, ?)", day, n)
+	}
+
+	from, to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	report, err := s.computeFunnel(t.Context(), from, to, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []funnelStage{
+		{Stage: stageFormView, Count: 10, StepRate: 100, OverallRate: 100, Label: "10 form views"},
+		{Stage: stageSubmitted, Count: 5, StepRate: 50, OverallRate: 50, Label: "5 subscribers"},
+		{Stage: stageConfirmationSent, Count: 3, StepRate: 60, OverallRate: 30, Label: "3 subscribers"},
+		{Stage: stageDelivered, Count: 2, StepRate: 66.66, OverallRate: 20, Label: "2 subscribers"},
+		{Stage: stageLinkClicked, Count: 1, StepRate: 50, OverallRate: 10, Label: "1 subscriber"},
+		{Stage: stageVerified, Count: 1, StepRate: 100, OverallRate: 10, Label: "1 subscriber"},
+	}
+	if len(report.Stages) != len(want) {
+		t.Fatalf("%d stages, want %d", len(report.Stages), len(want))
+	}
+	for i, got := range report.Stages {
+		if got != want[i] {
+			t.Errorf("stage %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if report.From != "2025-01-01" || report.To != "2025-01-31" {
+		t.Errorf("range = %s..%s", report.From, report.To)
+	}
+
+	// An empty range: no division by zero, and Arabic zero forms
+	empty, err := s.computeFunnel(t.Context(), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC), "ar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range empty.Stages[1:] {
+		if st.Count != 0 || st.StepRate != 0 || st.OverallRate != 0 || st.Label != "لا يوجد مشتركون" {
+			t.Errorf("empty range %s = %+v", st.Stage, st)
+		}
+	}
+	if st := empty.Stages[0]; st.Label != "لا توجد مشاهدات" {
+		t.Errorf("empty range form views label = %q", st.Label)
+	}
+}
+
+func TestHandleFunnel(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	seedFunnel(t, s, map[string][]string{"2025-03-02 10:00:00": fullFunnel[:2]})
+	auth := []string{"Authorization", "Bearer " + testAdminToken}
+
+	resp, body := do(t, ts, http.MethodGet, "/admin/funnel?from=2025-03-01&to=2025-03-02&lang=ar", nil, auth...)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/funnel = %d %q", resp.StatusCode, body)
+	}
+	var report funnelReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		t.Fatal(err)
+	}
+	if st := report.Stages[2]; st.Stage != stageConfirmationSent || st.Count != 1 || st.StepRate != 100 {
+		t.Errorf("confirmation_sent = %+v", st)
+	}
+
+	for _, q := range []string{"from=March", "to=2025-13-01", "from=2025-03-02&to=2025-03-01"} {
+		if resp, _ := do(t, ts, http.MethodGet, "/admin/funnel?"+q, nil, auth...); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("?%s = %d, want 400", q, resp.StatusCode)
+		}
+	}
+}
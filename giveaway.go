@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Giveaway draws among verified subscribers, auditable with commit-reveal:
+//
+//  1. POST /admin/giveaway/commit takes the terms of the draw (winner count
+//     and filters), works out the candidates they leave and picks a secret
+//     32-byte seed. It publishes SHA-256(seed), the commitment, next to the
+//     terms and their SHA-256. Share the public page, GET /giveaway/{id},
+//     before the draw.
+//  2. POST /admin/giveaway/draw with draw=<id> selects the winners and
+//     reveals the seed. It refuses when the candidates are no longer the
+//     committed ones, since the result would not be the one promised.
+//
+// Anyone can then check that SHA-256(seed) is the commitment and
+// SHA-256(terms) the terms digest, and redo the selection: every candidate
+// id (decimal) gets the key HMAC-SHA256(seed, id), and the winners are the
+// candidates with the lowest keys in hex order.
+//
+// Without draw=<id> the terms are committed and drawn in one step with a
+// fresh seed, so repeated draws differ; passing seed= pins it and makes a
+// draw reproducible. An operator who picks the seed can try seeds until one
+// suits them, so a commitment refuses a pinned seed and the public page
+// flags a pinned draw as proving nothing.
+//
+// The terms are write-protected by a trigger once committed (migration 9),
+// the whole row once drawn (migration 5).
+
+const (
+	giveawaySeedBytes  = 32
+	maxGiveawayWinners = 1000
+	maxGiveawayExclude = 10000
+
+	giveawayPinnedWarning = "The seed was pinned by the operator instead of drawn at random: the draw can be repeated, but it proves nothing about fairness."
+)
+
+type giveawayFilters struct {
+	SignedUpBefore     string  `json:"signed_up_before,omitempty"`
+	ExcludeDraws       []int64 `json:"exclude_draws,omitempty"`
+	ExcludeSubscribers []int   `json:"exclude_subscribers,omitempty"`
+}
+
+// giveawayTerms is everything besides the seed that decides a draw.
+type giveawayTerms struct {
+	Filters    giveawayFilters
+	Winners    int
+	Candidates []int
+}
+
+// String is the committed form of the terms, one key=value per line with
+// ids comma-separated and no trailing newline.
+func (t giveawayTerms) String() string {
+	return "winners=" + strconv.Itoa(t.Winners) +
+		"\nsigned_up_before=" + t.Filters.SignedUpBefore +
+		"\nexclude_draws=" + joinIDs(t.Filters.ExcludeDraws) +
+		"\nexclude_subscribers=" + joinIDs(t.Filters.ExcludeSubscribers) +
+		"\ncandidates=" + joinIDs(t.Candidates)
+}
+
+func (t giveawayTerms) digest() string {
+	sum := sha256.Sum256([]byte(t.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+type giveawayWinner struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+type giveawayDraw struct {
+	ID              int64            `json:"id"`
+	Status          string           `json:"status"`
+	Commitment      string           `json:"commitment"`
+	SeedPinned      bool             `json:"seed_pinned"`
+	Warning         string           `json:"warning,omitempty"`
+	Seed            *string          `json:"seed"`
+	CommittedAt     string           `json:"committed_at"`
+	CommittedBy     string           `json:"committed_by"`
+	Terms           string           `json:"terms,omitempty"`
+	TermsDigest     string           `json:"terms_digest,omitempty"`
+	Filters         *giveawayFilters `json:"filters,omitempty"`
+	WinnerCount     int              `json:"winner_count,omitempty"`
+	CandidateCount  int              `json:"candidate_count,omitempty"`
+	CandidateDigest string           `json:"candidate_digest,omitempty"`
+	CandidateIDs    []int            `json:"candidate_ids,omitempty"`
+	Winners         []giveawayWinner `json:"winners,omitempty"`
+	DrawnAt         *string          `json:"drawn_at"`
+	DrawnBy         string           `json:"drawn_by,omitempty"`
+}
+
+// handleGiveawayCommit serves POST /admin/giveaway/commit. It takes the
+// same terms as a draw (winners, signed_up_before, exclude_draws, exclude)
+// but no seed: a commitment always gets a random one.
+func (s *Server) handleGiveawayCommit(w http.ResponseWriter, r *http.Request) {
+	if err := parseLimitedForm(w, r); err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+	if r.PostFormValue("seed") != "" {
+		s.writeFormError(w, r, &formError{Field: "seed", Problem: "can't be pinned on a commitment, since a chosen seed proves nothing; pin it on a one-step draw instead"})
+		return
+	}
+	terms, ok := s.readGiveawayTerms(w, r)
+	if !ok {
+		return
+	}
+	seed, _, err := giveawaySeed(r)
+	if err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+
+	id, err := s.insertGiveawayCommitment(r.Context(), seed, false, terms, adminActor(r))
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to record commitment")
+		return
+	}
+	log.Printf("🔐 Audit: actor=%s command=giveaway-commit draw=%d candidates=%d", adminActor(r), id, len(terms.Candidates))
+	s.writeGiveaway(w, r, http.StatusCreated, id, false)
+}
+
+// handleGiveawayDraw serves POST /admin/giveaway/draw. With draw (a
+// committed id) nothing else is accepted: the terms were fixed at commit
+// time. Otherwise the fields are winners (required), seed (to pin),
+// signed_up_before (YYYY-MM-DD), exclude_draws (ids of earlier draws whose
+// winners can't win again) and exclude (addresses), both comma-separated.
+func (s *Server) handleGiveawayDraw(w http.ResponseWriter, r *http.Request) {
+	if err := parseLimitedForm(w, r); err != nil {
+		s.writeFormError(w, r, err)
+		return
+	}
+
+	// Use a published commitment, or commit on the spot
+	actor := adminActor(r)
+	var id int64
+	var seed []byte
+	var terms giveawayTerms
+	if v := r.PostFormValue("draw"); v != "" {
+		for _, field := range []string{"seed", "winners", "signed_up_before", "exclude_draws", "exclude"} {
+			if r.PostFormValue(field) != "" {
+				s.writeFormError(w, r, &formError{Field: field, Problem: "can't be combined with draw: it was fixed at commit time"})
+				return
+			}
+		}
+		var err error
+		if id, err = strconv.ParseInt(v, 10, 64); err != nil {
+			s.writeFormError(w, r, &formError{Field: "draw", Problem: "must be an integer"})
+			return
+		}
+		var seedHex string
+		var filters, digest, drawn sql.NullString
+		var winners, committedCount sql.NullInt64
+		err = s.db.QueryRowContext(r.Context(), `SELECT seed, filters, winner_count, candidate_count, terms_digest, drawn_at
+			FROM giveaway_draws WHERE id = ?`, id).Scan(&seedHex, &filters, &winners, &committedCount, &digest, &drawn)
+		if err == sql.ErrNoRows {
+			s.writeError(w, r, http.StatusNotFound, "❌ Giveaway draw not found")
+			return
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read giveaway draw")
+			return
+		}
+		if drawn.Valid {
+			s.writeError(w, r, http.StatusConflict, "❌ That commitment has already been drawn")
+			return
+		}
+		if !digest.Valid {
+			s.writeError(w, r, http.StatusConflict, "❌ That commitment was made without its terms; commit the draw again")
+			return
+		}
+		json.Unmarshal([]byte(filters.String), &terms.Filters)
+		terms.Winners = int(winners.Int64)
+		if terms.Candidates, err = s.giveawayCandidates(r.Context(), terms.Filters); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to fetch subscribers")
+			return
+		}
+		if terms.digest() != digest.String {
+			s.writeError(w, r, http.StatusConflict, "❌ The eligible subscribers have changed since the commitment ("+
+				plural("en", int(committedCount.Int64), "subscribers")+" committed, "+strconv.Itoa(len(terms.Candidates))+" now); commit the draw again")
+			return
+		}
+		seed, _ = hex.DecodeString(seedHex)
+	} else {
+		var ok, pinned bool
+		if terms, ok = s.readGiveawayTerms(w, r); !ok {
+			return
+		}
+		var err error
+		if seed, pinned, err = giveawaySeed(r); err != nil {
+			s.writeFormError(w, r, err)
+			return
+		}
+		if id, err = s.insertGiveawayCommitment(r.Context(), seed, pinned, terms, actor); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to record commitment")
+			return
+		}
+	}
+
+	winners := selectGiveawayWinners(seed, terms.Candidates, terms.Winners)
+	winnerJSON, _ := json.Marshal(winners)
+	res, err := s.db.ExecContext(r.Context(), `UPDATE giveaway_draws SET winner_ids = ?, drawn_at = CURRENT_TIMESTAMP, drawn_by = ?
+		WHERE id = ? AND drawn_at IS NULL`, string(winnerJSON), actor, id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to record draw")
+		return
+	}
+	if changed, _ := res.RowsAffected(); changed == 0 {
+		s.writeError(w, r, http.StatusConflict, "❌ That commitment has already been drawn")
+		return
+	}
+
+	log.Printf("🔐 Audit: actor=%s command=giveaway-draw draw=%d winners=%v", actor, id, winners)
+	w.Header().Set("Location", "/admin/giveaway/"+strconv.FormatInt(id, 10))
+	s.writeGiveaway(w, r, http.StatusCreated, id, true)
+}
+
+// readGiveawayTerms reads winners and the filters and works out the
+// candidates they leave. On a bad request it writes the error and returns
+// false.
+func (s *Server) readGiveawayTerms(w http.ResponseWriter, r *http.Request) (giveawayTerms, bool) {
+	var t giveawayTerms
+	for _, field := range []string{"topic", "tag"} {
+		if r.PostFormValue(field) != "" {
+			s.writeFormError(w, r, &formError{Field: field, Problem: "is not supported: subscribers have no topics or tags"})
+			return t, false
+		}
+	}
+
+	n, err := strconv.Atoi(r.PostFormValue("winners"))
+	if err != nil || n < 1 || n > maxGiveawayWinners {
+		s.writeFormError(w, r, &formError{Field: "winners", Problem: "must be a number from 1 to " + strconv.Itoa(maxGiveawayWinners)})
+		return t, false
+	}
+	t.Winners = n
+	if v := strings.TrimSpace(r.PostFormValue("signed_up_before")); v != "" {
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			s.writeFormError(w, r, &formError{Field: "signed_up_before", Problem: "must be a date like 2025-01-31"})
+			return t, false
+		}
+		t.Filters.SignedUpBefore = v
+	}
+	if t.Filters.ExcludeDraws, err = s.giveawayDrawIDs(r.Context(), r.PostFormValue("exclude_draws")); err != nil {
+		s.writeFormError(w, r, err)
+		return t, false
+	}
+	if t.Filters.ExcludeSubscribers, err = s.giveawayExcludedAddresses(r.Context(), r.PostFormValue("exclude")); err != nil {
+		s.writeFormError(w, r, err)
+		return t, false
+	}
+
+	if t.Candidates, err = s.giveawayCandidates(r.Context(), t.Filters); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to fetch subscribers")
+		return t, false
+	}
+	if len(t.Candidates) < n {
+		s.writeError(w, r, http.StatusUnprocessableEntity,
+			"❌ Asked for "+plural("en", n, "winners")+", but the filters match "+plural("en", len(t.Candidates), "subscribers"))
+		return t, false
+	}
+	return t, true
+}
+
+// handleGiveawayStatus serves GET /admin/giveaway/{id} with the winners'
+// full addresses.
+func (s *Server) handleGiveawayStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id must be an integer"})
+		return
+	}
+	s.writeGiveaway(w, r, http.StatusOK, id, false)
+}
+
+// handlePublicGiveaway serves GET /giveaway/{id}, the page to share with
+// participants: the commitment and the terms before the draw, then the seed
+// and the winners with masked addresses.
+func (s *Server) handlePublicGiveaway(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id must be an integer"})
+		return
+	}
+	s.writeGiveaway(w, r, http.StatusOK, id, true)
+}
+
+func (s *Server) writeGiveaway(w http.ResponseWriter, r *http.Request, status int, id int64, masked bool) {
+	d, err := s.loadGiveaway(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "giveaway draw not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read giveaway draw"})
+		return
+	}
+	if masked {
+		for i := range d.Winners {
+			d.Winners[i].Email = maskEmail(d.Winners[i].Email)
+		}
+	}
+	writeJSON(w, status, d)
+}
+
+func (s *Server) loadGiveaway(ctx context.Context, id int64) (giveawayDraw, error) {
+	d := giveawayDraw{ID: id}
+	var seed string
+	var filters, candidates, winners, drawnAt, drawnBy sql.NullString
+	var winnerCount, candidateCount sql.NullInt64
+	var digest, termsDigest sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT commitment, seed, seed_pinned, strftime('%Y-%m-%dT%H:%M:%SZ', committed_at), committed_by,
+		filters, winner_count, candidate_count, candidate_digest, candidate_ids, terms_digest, winner_ids,
+		strftime('%Y-%m-%dT%H:%M:%SZ', drawn_at), drawn_by
+		FROM giveaway_draws WHERE id = ?`, id).
+		Scan(&d.Commitment, &seed, &d.SeedPinned, &d.CommittedAt, &d.CommittedBy,
+			&filters, &winnerCount, &candidateCount, &digest, &candidates, &termsDigest, &winners, &drawnAt, &drawnBy)
+	if err != nil {
+		return d, err
+	}
+	if d.SeedPinned {
+		d.Warning = giveawayPinnedWarning
+	}
+
+	// The terms are public from the commitment on; rows committed before
+	// they were recorded only have them once drawn
+	d.WinnerCount, d.CandidateCount, d.CandidateDigest = int(winnerCount.Int64), int(candidateCount.Int64), digest.String
+	if filters.Valid {
+		d.Filters = &giveawayFilters{}
+		json.Unmarshal([]byte(filters.String), d.Filters)
+	}
+	json.Unmarshal([]byte(candidates.String), &d.CandidateIDs)
+	if termsDigest.Valid {
+		d.TermsDigest = termsDigest.String
+		d.Terms = giveawayTerms{*d.Filters, d.WinnerCount, d.CandidateIDs}.String()
+	}
+
+	d.Status = "committed"
+	if !drawnAt.Valid {
+		// The seed stays secret until the draw
+		return d, nil
+	}
+	d.Status = "drawn"
+	d.Seed, d.DrawnAt, d.DrawnBy = &seed, &drawnAt.String, drawnBy.String
+
+	var ids []int
+	json.Unmarshal([]byte(winners.String), &ids)
+	for _, wid := range ids {
+		winner := giveawayWinner{ID: wid}
+		// A winner who has since been deleted keeps their place without an address
+		s.db.QueryRowContext(ctx, "SELECT email FROM subscribers WHERE id = ?", wid).Scan(&winner.Email)
+		d.Winners = append(d.Winners, winner)
+	}
+	return d, nil
+}
+
+// giveawaySeed returns the pinned "seed" field, or a fresh random one.
+func giveawaySeed(r *http.Request) ([]byte, bool, error) {
+	v := strings.TrimSpace(r.PostFormValue("seed"))
+	if v == "" {
+		seed := make([]byte, giveawaySeedBytes)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, false, err
+		}
+		return seed, false, nil
+	}
+	seed, err := hex.DecodeString(v)
+	if err != nil || len(seed) != giveawaySeedBytes {
+		return nil, false, &formError{Field: "seed", Problem: "must be 64 hex digits"}
+	}
+	return seed, true, nil
+}
+
+// insertGiveawayCommitment records the seed's commitment together with the
+// terms it is drawn under.
+func (s *Server) insertGiveawayCommitment(ctx context.Context, seed []byte, pinned bool, t giveawayTerms, actor string) (int64, error) {
+	commitment := sha256.Sum256(seed)
+	filterJSON, _ := json.Marshal(t.Filters)
+	candidateJSON, _ := json.Marshal(t.Candidates)
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO giveaway_draws(commitment, seed, seed_pinned, committed_by,
+		filters, winner_count, candidate_count, candidate_digest, candidate_ids, terms_digest)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		hex.EncodeToString(commitment[:]), hex.EncodeToString(seed), pinned, actor,
+		string(filterJSON), t.Winners, len(t.Candidates), giveawayCandidateDigest(t.Candidates),
+		string(candidateJSON), t.digest()).Scan(&id)
+	return id, err
+}
+
+// giveawayDrawIDs parses exclude_draws and returns the ids of drawn rows.
+func (s *Server) giveawayDrawIDs(ctx context.Context, v string) ([]int64, error) {
+	var ids []int64
+	for f := range strings.SplitSeq(v, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, &formError{Field: "exclude_draws", Problem: "must be comma-separated draw ids"}
+		}
+		var drawn sql.NullString
+		if err := s.db.QueryRowContext(ctx, "SELECT drawn_at FROM giveaway_draws WHERE id = ?", id).Scan(&drawn); err != nil || !drawn.Valid {
+			return nil, &formError{Field: "exclude_draws", Problem: "has " + f + ", which is not a finished draw"}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// giveawayExcludedAddresses maps the exclude field to subscriber ids; an
+// address with no subscriber is ignored since it can't win anyway.
+func (s *Server) giveawayExcludedAddresses(ctx context.Context, v string) ([]int, error) {
+	fields := strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' })
+	if len(fields) > maxGiveawayExclude {
+		return nil, &formError{Field: "exclude", Problem: "has too many addresses"}
+	}
+	var ids []int
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if err := validateEmail(f); err != nil {
+			return nil, &formError{Field: "exclude", Problem: "has " + f + ", which " + err.Error()}
+		}
+		var id int
+		if err := s.db.QueryRowContext(ctx, "SELECT id FROM subscribers WHERE email = ?", normalizeEmail(f)).Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+// giveawayCandidates returns the eligible subscriber ids in ascending order.
+func (s *Server) giveawayCandidates(ctx context.Context, f giveawayFilters) ([]int, error) {
+	query := "SELECT id FROM subscribers WHERE verified = 1 AND unsubscribed_at IS NULL"
+	var args []any
+	if f.SignedUpBefore != "" {
+		query += " AND created_at < ?"
+		args = append(args, f.SignedUpBefore)
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	excluded := map[int]bool{}
+	for _, id := range f.ExcludeSubscribers {
+		excluded[id] = true
+	}
+	for _, drawID := range f.ExcludeDraws {
+		var winners string
+		if err := s.db.QueryRowContext(ctx, "SELECT winner_ids FROM giveaway_draws WHERE id = ?", drawID).Scan(&winners); err != nil {
+			return nil, err
+		}
+		var ids []int
+		json.Unmarshal([]byte(winners), &ids)
+		for _, id := range ids {
+			excluded[id] = true
+		}
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if !excluded[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// selectGiveawayWinners ranks candidates by HMAC-SHA256(seed, id) and keeps
+// the first n, in rank order.
+func selectGiveawayWinners(seed []byte, candidates []int, n int) []int {
+	keys := make(map[int]string, len(candidates))
+	for _, id := range candidates {
+		mac := hmac.New(sha256.New, seed)
+		mac.Write([]byte(strconv.Itoa(id)))
+		keys[id] = hex.EncodeToString(mac.Sum(nil))
+	}
+	ranked := slices.Clone(candidates)
+	slices.SortFunc(ranked, func(a, b int) int { return strings.Compare(keys[a], keys[b]) })
+	return ranked[:n]
+}
+
+// giveawayCandidateDigest is SHA-256 over the comma-joined candidate ids.
+func giveawayCandidateDigest(candidates []int) string {
+	sum := sha256.Sum256([]byte(joinIDs(candidates)))
+	return hex.EncodeToString(sum[:])
+}
+
+func joinIDs[T int | int64](ids []T) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(int64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// maskEmail keeps the first character of the name and of the domain and
+// the top-level domain: "amira@example.com" becomes "a***@e***.com".
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return "***"
+	}
+	local, domain := email[:at], email[at+1:]
+	first, _ := utf8.DecodeRuneInString(local)
+	masked := string(first) + "***@"
+	if dot := strings.LastIndexByte(domain, '.'); dot > 0 {
+		d, _ := utf8.DecodeRuneInString(domain)
+		return masked + string(d) + "***" + domain[dot:]
+	}
+	return masked + "***"
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// addVerified inserts n verified subscribers and returns their ids.
+func addVerified(t *testing.T, s *Server, n int) []int {
+	t.Helper()
+	var ids []int
+	base := countRows(t, s.db, "subscribers")
+	for i := 0; i < n; i++ {
+		var id int
+		err := s.db.QueryRow("INSERT INTO subscribers(email, verified, created_at) VALUES(?, 1, CURRENT_TIMESTAMP) RETURNING id",
+			fmt.Sprintf("winner%d@example.com", base+i)).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// giveaway posts fields to path as the admin and decodes the draw.
+func giveaway(t *testing.T, ts *httptest.Server, path string, fields map[string]string, wantStatus int) giveawayDraw {
+	t.Helper()
+	resp, body := do(t, ts, http.MethodPost, path, fields, "Authorization", "Bearer "+testAdminToken)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("POST %s %v = %d %q, want %d", path, fields, resp.StatusCode, body, wantStatus)
+	}
+	var d giveawayDraw
+	json.Unmarshal([]byte(body), &d)
+	return d
+}
+
+func publicGiveaway(t *testing.T, ts *httptest.Server, id int64) giveawayDraw {
+	t.Helper()
+	resp, body := do(t, ts, http.MethodGet, "/giveaway/"+strconv.FormatInt(id, 10), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /giveaway/%d = %d %q", id, resp.StatusCode, body)
+	}
+	var d giveawayDraw
+	if err := json.Unmarshal([]byte(body), &d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// What a participant does with the public page: check both digests, then
+// redo the selection from the revealed seed.
+func TestGiveawayCommitReveal(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	candidates := addVerified(t, s, 10)
+
+	committed := giveaway(t, ts, "/admin/giveaway/commit", map[string]string{"winners": "3"}, http.StatusCreated)
+	before := publicGiveaway(t, ts, committed.ID)
+	if before.Status != "committed" || before.Seed != nil {
+		t.Fatalf("before the draw the public page shows status %q, seed %v", before.Status, before.Seed)
+	}
+	if !slices.Equal(before.CandidateIDs, candidates) || before.WinnerCount != 3 {
+		t.Errorf("committed terms: candidates %v, winners %d; want %v and 3", before.CandidateIDs, before.WinnerCount, candidates)
+	}
+	if before.Terms == "" || sha256Hex([]byte(before.Terms)) != before.TermsDigest {
+		t.Errorf("terms %q don't hash to the published digest %s", before.Terms, before.TermsDigest)
+	}
+	if before.SeedPinned || before.Warning != "" {
+		t.Errorf("random seed flagged: pinned %v, warning %q", before.SeedPinned, before.Warning)
+	}
+
+	giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"draw": strconv.FormatInt(committed.ID, 10)}, http.StatusCreated)
+	after := publicGiveaway(t, ts, committed.ID)
+	if after.Status != "drawn" || after.Seed == nil {
+		t.Fatalf("after the draw the public page shows status %q, seed %v", after.Status, after.Seed)
+	}
+	if after.Commitment != before.Commitment || after.TermsDigest != before.TermsDigest {
+		t.Error("the commitment or terms changed between commit and draw")
+	}
+	seed, err := hex.DecodeString(*after.Seed)
+	if err != nil || sha256Hex(seed) != before.Commitment {
+		t.Fatalf("revealed seed %s doesn't match the commitment %s", *after.Seed, before.Commitment)
+	}
+
+	want := selectGiveawayWinners(seed, before.CandidateIDs, before.WinnerCount)
+	var got []int
+	for _, w := range after.Winners {
+		got = append(got, w.ID)
+		if !strings.Contains(w.Email, "***") {
+			t.Errorf("public page shows an unmasked address %q", w.Email)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("winners %v, redone from the seed %v", got, want)
+	}
+}
+
+func TestGiveawayDrawRefusesChangedCandidates(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	candidates := addVerified(t, s, 5)
+	committed := giveaway(t, ts, "/admin/giveaway/commit", map[string]string{"winners": "2"}, http.StatusCreated)
+	draw := map[string]string{"draw": strconv.FormatInt(committed.ID, 10)}
+
+	// One more subscriber verifies
+	addVerified(t, s, 1)
+	giveaway(t, ts, "/admin/giveaway/draw", draw, http.StatusConflict)
+
+	// Same count, different people
+	s.db.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = ?", candidates[0])
+	giveaway(t, ts, "/admin/giveaway/draw", draw, http.StatusConflict)
+
+	if d := publicGiveaway(t, ts, committed.ID); d.Status != "committed" || d.Seed != nil {
+		t.Errorf("a refused draw left status %q, seed %v", d.Status, d.Seed)
+	}
+
+	// Back to the committed set, the draw goes ahead
+	s.db.Exec("UPDATE subscribers SET unsubscribed_at = NULL WHERE id = ?", candidates[0])
+	s.db.Exec("UPDATE subscribers SET verified = 0 WHERE id NOT IN (?, ?, ?, ?, ?)",
+		candidates[0], candidates[1], candidates[2], candidates[3], candidates[4])
+	giveaway(t, ts, "/admin/giveaway/draw", draw, http.StatusCreated)
+}
+
+func TestGiveawayDrawTakesTermsFromCommitment(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	addVerified(t, s, 5)
+	committed := giveaway(t, ts, "/admin/giveaway/commit", map[string]string{"winners": "2"}, http.StatusCreated)
+	id := strconv.FormatInt(committed.ID, 10)
+
+	for _, field := range []string{"winners", "seed", "signed_up_before", "exclude_draws", "exclude"} {
+		giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"draw": id, field: "1"}, http.StatusBadRequest)
+	}
+	if _, err := s.db.Exec("UPDATE giveaway_draws SET winner_count = 5 WHERE id = ?", committed.ID); err == nil {
+		t.Error("the committed winner count could be changed")
+	}
+	d := giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"draw": id}, http.StatusCreated)
+	if len(d.Winners) != 2 {
+		t.Errorf("%d winners, want the committed 2", len(d.Winners))
+	}
+	giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"draw": id}, http.StatusConflict)
+}
+
+func TestGiveawayPinnedSeed(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	addVerified(t, s, 50)
+	pin := hex.EncodeToString(make([]byte, giveawaySeedBytes))
+
+	giveaway(t, ts, "/admin/giveaway/commit", map[string]string{"winners": "5", "seed": pin}, http.StatusBadRequest)
+
+	first := giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"winners": "5", "seed": pin}, http.StatusCreated)
+	second := giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"winners": "5", "seed": pin}, http.StatusCreated)
+	if !slices.Equal(winnerIDs(first), winnerIDs(second)) {
+		t.Errorf("pinned draws differ: %v and %v", winnerIDs(first), winnerIDs(second))
+	}
+	if d := publicGiveaway(t, ts, first.ID); !d.SeedPinned || d.Warning == "" {
+		t.Errorf("public page of a pinned draw: pinned %v, warning %q", d.SeedPinned, d.Warning)
+	}
+
+	a := giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"winners": "5"}, http.StatusCreated)
+	b := giveaway(t, ts, "/admin/giveaway/draw", map[string]string{"winners": "5"}, http.StatusCreated)
+	if slices.Equal(winnerIDs(a), winnerIDs(b)) {
+		t.Errorf("two unpinned draws picked the same winners %v", winnerIDs(a))
+	}
+}
+
+func winnerIDs(d giveawayDraw) []int {
+	var ids []int
+	for _, w := range d.Winners {
+		ids = append(ids, w.ID)
+	}
+	return ids
+}
@@ -6,6 +6,7 @@ require (
 	github.com/gorilla/sessions v1.4.0
 	github.com/joho/godotenv v1.5.1
 	github.com/markbates/goth v1.81.0
+	golang.org/x/time v0.11.0
 )
 
 require (
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GET /health is the probe for load balancers and Kubernetes: 200 when
+// every check passes, 503 otherwise. Unlike /status it reports the error
+// text, so it says why; it is unauthenticated and works in maintenance
+// mode, since the server itself is still fine then. The SMTP check only
+// dials the server (no EHLO, no login) and is off unless
+// HEALTH_CHECK_SMTP=1, so a mail outage doesn't pull the site out of
+// rotation by default.
+
+const (
+	healthCheckTimeout = 2 * time.Second
+	healthOK           = "ok"
+	healthSkipped      = "skipped"
+)
+
+type healthReport struct {
+	Status   string `json:"status"` // ok or degraded
+	Database string `json:"database"`
+	SMTP     string `json:"smtp"`
+}
+
+func (s *Server) checkSMTP(ctx context.Context) string {
+	if !s.cfg.HealthCheckSMTP || simulation.enabled {
+		return healthSkipped
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.smtp.host, strconv.Itoa(s.smtp.port)))
+	if err != nil {
+		return err.Error()
+	}
+	conn.Close()
+	return healthOK
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: healthOK, Database: healthOK}
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	if err := s.db.PingContext(ctx); err != nil {
+		report.Database = err.Error()
+	}
+	cancel()
+	report.SMTP = s.checkSMTP(r.Context())
+
+	status := http.StatusOK
+	if report.Database != healthOK || (report.SMTP != healthOK && report.SMTP != healthSkipped) {
+		report.Status = "degraded"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CLDR plural categories. Arabic uses all six; English only one/other.
+const (
+	pluralZero  = "zero"
+	pluralOne   = "one"
+	pluralTwo   = "two"
+	pluralFew   = "few"
+	pluralMany  = "many"
+	pluralOther = "other"
+)
+
+const defaultLang = "en"
+
+// pluralCategory applies the CLDR cardinal rules for integer counts.
+func pluralCategory(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ar":
+		switch mod := n % 100; {
+		case n == 0:
+			return pluralZero
+		case n == 1:
+			return pluralOne
+		case n == 2:
+			return pluralTwo
+		case mod >= 3 && mod <= 10:
+			return pluralFew
+		case mod >= 11 && mod <= 99:
+			return pluralMany
+		}
+		return pluralOther
+	default:
+		if n == 1 {
+			return pluralOne
+		}
+		return pluralOther
+	}
+}
+
+// Message catalog: lang → key → plural category → format. A "%s" verb
+// receives the count already formatted for the locale. Missing categories
+// fall back to "other".
+var pluralCatalog = map[string]map[string]map[string]string{
+	"en": {
+		"subscribers": {
+			pluralOne:   "%s subscriber",
+			pluralOther: "%s subscribers",
+		},
+		"new_subscribers": {
+			pluralOne:   "%s new subscriber",
+			pluralOther: "%s new subscribers",
+		},
+		"form_views": {
+			pluralOne:   "%s form view",
+			pluralOther: "%s form views",
+		},
+		"winners": {
+			pluralOne:   "%s winner",
+			pluralOther: "%s winners",
+		},
+	},
+	"ar": {
+		"subscribers": {
+			pluralZero:  "لا يوجد مشتركون",
+			pluralOne:   "مشترك واحد",
+			pluralTwo:   "مشتركان",
+			pluralFew:   "%s مشتركين",
+			pluralMany:  "%s مشتركًا",
+			pluralOther: "%s مشترك",
+		},
+		"new_subscribers": {
+			pluralZero:  "لا يوجد مشتركون جدد",
+			pluralOne:   "مشترك جديد واحد",
+			pluralTwo:   "مشتركان جديدان",
+			pluralFew:   "%s مشتركين جدد",
+			pluralMany:  "%s مشتركًا جديدًا",
+			pluralOther: "%s مشترك جديد",
+		},
+		"form_views": {
+			pluralZero:  "لا توجد مشاهدات",
+			pluralOne:   "مشاهدة واحدة",
+			pluralTwo:   "مشاهدتان",
+			pluralFew:   "%s مشاهدات",
+			pluralMany:  "%s مشاهدةً",
+			pluralOther: "%s مشاهدة",
+		},
+		"winners": {
+			pluralZero:  "لا يوجد فائزون",
+			pluralOne:   "فائز واحد",
+			pluralTwo:   "فائزان",
+			pluralFew:   "%s فائزين",
+			pluralMany:  "%s فائزًا",
+			pluralOther: "%s فائز",
+		},
+	},
+}
+
+// plural renders the catalog entry for key in the grammatical form that
+// matches count. Unknown languages use English; unknown keys render the key.
+func plural(lang string, count int, key string) string {
+	entries, ok := pluralCatalog[lang]
+	if !ok {
+		lang = defaultLang
+		entries = pluralCatalog[lang]
+	}
+	forms, ok := entries[key]
+	if !ok {
+		return key
+	}
+	format, ok := forms[pluralCategory(lang, count)]
+	if !ok {
+		format = forms[pluralOther]
+	}
+	if !strings.Contains(format, "%s") {
+		return format
+	}
+	return fmt.Sprintf(format, formatNumber(lang, count))
+}
+
+var arabicIndicDigits = []rune("٠١٢٣٤٥٦٧٨٩")
+
+// formatNumber groups thousands using the locale's separator and digits.
+func formatNumber(lang string, n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	sep := ","
+	if lang == "ar" {
+		sep = "٬"
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		if lang == "ar" {
+			b.WriteRune(arabicIndicDigits[d-'0'])
+		} else {
+			b.WriteRune(d)
+		}
+	}
+	return b.String()
+}
+
+// i18nFuncs are the helpers every email and broadcast template can call,
+// with the language first since most copy is in both: {{plural "ar"
+// .Count "subscribers"}} and {{number "ar" .Count}}. It suits both
+// text/template and html/template.
+var i18nFuncs = map[string]any{
+	"plural": plural,
+	"number": formatNumber,
+}
+
+// requestLang picks "ar" or "en" from an explicit lang value, defaulting to English.
+func requestLang(v string) string {
+	if _, ok := pluralCatalog[v]; ok {
+		return v
+	}
+	return defaultLang
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestArabicPlurals(t *testing.T) {
+	for _, tc := range []struct {
+		n                     int
+		category, subscribers string
+	}{
+		{0, pluralZero, "لا يوجد مشتركون"},
+		{1, pluralOne, "مشترك واحد"},
+		{2, pluralTwo, "مشتركان"},
+		{3, pluralFew, "٣ مشتركين"},
+		{10, pluralFew, "١٠ مشتركين"},
+		{11, pluralMany, "١١ مشتركًا"},
+		{99, pluralMany, "٩٩ مشتركًا"},
+		{100, pluralOther, "١٠٠ مشترك"},
+		{101, pluralOther, "١٠١ مشترك"},
+		{103, pluralFew, "١٠٣ مشتركين"},
+		{1011, pluralMany, "١٬٠١١ مشتركًا"},
+	} {
+		if got := pluralCategory("ar", tc.n); got != tc.category {
+			t.Errorf("pluralCategory(ar, %d) = %s, want %s", tc.n, got, tc.category)
+		}
+		if got := plural("ar", tc.n, "subscribers"); got != tc.subscribers {
+			t.Errorf("plural(ar, %d) = %q, want %q", tc.n, got, tc.subscribers)
+		}
+	}
+}
+
+func TestEnglishPlurals(t *testing.T) {
+	for n, want := range map[int]string{0: "0 winners", 1: "1 winner", 2: "2 winners", 1000: "1,000 winners"} {
+		if got := plural("en", n, "winners"); got != want {
+			t.Errorf("plural(en, %d) = %q, want %q", n, got, want)
+		}
+	}
+	if got := plural("fr", 1, "winners"); got != "1 winner" {
+		t.Errorf("an unknown language = %q, want English", got)
+	}
+	if got := plural("ar", 5, "no_such_key"); got != "no_such_key" {
+		t.Errorf("an unknown key = %q, want the key", got)
+	}
+}
+
+func TestPluralInTemplates(t *testing.T) {
+	tmpl := template.Must(template.New("t").Funcs(i18nFuncs).Parse(`{{plural "ar" .N "winners"}} / {{plural "en" .N "winners"}} / {{number "ar" 1234567}}`))
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]int{"N": 11}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "١١ فائزًا / 11 winners / ١٬٢٣٤٬٥٦٧"; b.String() != want {
+		t.Errorf("template = %q, want %q", b.String(), want)
+	}
+
+	// Broadcast bodies can use them too
+	body, err := parseCampaignBody(`{{plural "ar" 2 "subscribers"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := renderCampaignBody(body, 1); got != "مشتركان" {
+		t.Errorf("broadcast body = %q", got)
+	}
+}
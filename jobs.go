@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Background export jobs. An export too large to stream records a row in
+// jobs and answers 202; a goroutine writes the file into EXPORT_DIR,
+// updating rows_done as it goes. GET /admin/jobs/{id} reports progress and,
+// once done, a signed download link valid for exportLinkTTL, so the file
+// can be fetched without the admin token. POST /admin/jobs/{id}/cancel
+// stops a running job; failed jobs keep their error.
+//
+// Finished files are kept for exportRetention. An hourly sweep then deletes
+// them, along with any file in EXPORT_DIR that no job owns, such as the
+// temp file of a job cut off by a restart (marked failed at startup).
+
+const (
+	jobKindDiff = "export_diff"
+
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+	// Done, and the retention sweep has deleted the file
+	jobExpired = "expired"
+
+	exportSyncMaxRows   = 5000
+	exportProgressEvery = 1000
+	exportLinkTTL       = time.Hour
+	exportRetention     = 24 * time.Hour
+	exportSweepInterval = time.Hour
+	exportTokenPurpose  = "export"
+	exportFilePrefix    = "job-"
+
+	defaultExportDir = "./subscribe/exports"
+)
+
+var exportDir = defaultExportDir
+
+// Cancel functions of the jobs running in this process; shutdown waits for
+// them through jobsRunning, and for the retention sweep through sweeping.
+var (
+	runningJobs sync.Map // int64 -> context.CancelCauseFunc
+	jobsRunning sync.WaitGroup
+
+	stopSweep chan struct{}
+	sweeping  sync.WaitGroup
+)
+
+var (
+	errJobCancelled = errors.New("cancelled by an admin")
+	errShuttingDown = errors.New("interrupted by shutdown")
+)
+
+// exportFunc writes one export to w, calling progress with the running row
+// count.
+type exportFunc func(ctx context.Context, w io.Writer, progress func(rows int64)) error
+
+type exportJob struct {
+	ID         int64   `json:"id"`
+	Kind       string  `json:"kind"`
+	Format     string  `json:"format"`
+	Status     string  `json:"status"`
+	RowsDone   int64   `json:"rows_done"`
+	RowsTotal  int64   `json:"rows_total"` // counted when the job started
+	Error      *string `json:"error"`
+	CreatedAt  string  `json:"created_at"`
+	FinishedAt *string `json:"finished_at"`
+	// Set while the file is kept; good for exportLinkTTL
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func (s *Server) startExportJobs(c *Config) {
+	exportDir = c.ExportDir
+	if err := os.MkdirAll(exportDir, 0o700); err != nil {
+		log.Fatalf("❌ Failed to create EXPORT_DIR: %v", err)
+	}
+	res, err := s.db.Exec("UPDATE jobs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE status = ?",
+		jobFailed, "interrupted by a restart", jobRunning)
+	if err != nil {
+		log.Fatalf("❌ Failed to recover export jobs: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("⚠️ Marked %d export jobs interrupted by the last shutdown as failed", n)
+	}
+	stopSweep = make(chan struct{})
+	sweeping.Add(1)
+	go s.runExportSweep(stopSweep)
+}
+
+// runExportSweep runs sweepExports now and every exportSweepInterval until
+// stop is closed.
+func (s *Server) runExportSweep(stop <-chan struct{}) {
+	defer sweeping.Done()
+	t := time.NewTicker(exportSweepInterval)
+	defer t.Stop()
+	for {
+		s.sweepExports()
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// stopExportJobs cancels the running jobs and stops the retention sweep,
+// then waits for them to finish, up to the deadline in ctx.
+func stopExportJobs(ctx context.Context) {
+	if stopSweep != nil {
+		close(stopSweep)
+		stopSweep = nil
+	}
+	running := 0
+	runningJobs.Range(func(_, cancel any) bool {
+		cancel.(context.CancelCauseFunc)(errShuttingDown)
+		running++
+		return true
+	})
+	if running > 0 {
+		log.Printf("🛑 Stopping %d export jobs", running)
+	}
+	done := make(chan struct{})
+	go func() {
+		jobsRunning.Wait()
+		sweeping.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// startExportJob records a job for write, starts it and answers 202 with
+// its status.
+func (s *Server) startExportJob(w http.ResponseWriter, r *http.Request, kind string, params any, format string, total int64, write exportFunc) {
+	paramsJSON, _ := json.Marshal(params)
+	var id int64
+	err := s.db.QueryRowContext(r.Context(), `INSERT INTO jobs(kind, params, format, status, rows_total, created_by)
+		VALUES(?, ?, ?, ?, ?, ?) RETURNING id`,
+		kind, string(paramsJSON), format, jobRunning, total, adminActor(r)).Scan(&id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to record export job")
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	runningJobs.Store(id, cancel)
+	jobsRunning.Add(1)
+	go s.runExportJob(ctx, id, format, write)
+	log.Printf("📤 Export job %d started: %s, about %d rows", id, kind, total)
+
+	job, err := s.loadJob(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to read export job")
+		return
+	}
+	w.Header().Set("Location", "/admin/jobs/"+strconv.FormatInt(id, 10))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func exportFileName(id int64, format string) string {
+	return exportFilePrefix + strconv.FormatInt(id, 10) + "." + format
+}
+
+// runExportJob writes to a temp file and renames it into place, so a file
+// under its final name is always complete.
+func (s *Server) runExportJob(ctx context.Context, id int64, format string, write exportFunc) {
+	defer jobsRunning.Done()
+	defer runningJobs.Delete(id)
+
+	final := filepath.Join(exportDir, exportFileName(id, format))
+	tmp := final + ".tmp"
+	rows, err := s.writeExportFile(ctx, id, tmp, write)
+	if err == nil {
+		err = os.Rename(tmp, final)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		status, msg := jobFailed, err.Error()
+		if cause := context.Cause(ctx); cause != nil {
+			msg = cause.Error()
+			if cause == errJobCancelled {
+				status = jobCancelled
+			}
+		}
+		_, dbErr := s.db.Exec("UPDATE jobs SET status = ?, error = ?, rows_done = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?",
+			status, msg, rows, id)
+		if dbErr != nil {
+			s.logError(context.Background(), componentStore, "⚠️ Export job: could not save status", dbErr, "job_id", id)
+		}
+		log.Printf("⚠️ Export job %d %s after %d rows: %s", id, status, rows, msg)
+		return
+	}
+
+	_, err = s.db.Exec("UPDATE jobs SET status = ?, file = ?, rows_done = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?",
+		jobDone, final, rows, id)
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Export job: could not save status", err, "job_id", id)
+		return
+	}
+	log.Printf("✅ Export job %d finished: %d rows", id, rows)
+}
+
+func (s *Server) writeExportFile(ctx context.Context, id int64, path string, write exportFunc) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	bw := bufio.NewWriter(f)
+	err = write(ctx, bw, func(n int64) {
+		rows = n
+		if n%exportProgressEvery == 0 {
+			// Progress is best effort; the final count is saved either way
+			s.db.Exec("UPDATE jobs SET rows_done = ? WHERE id = ?", n, id)
+		}
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return rows, err
+}
+
+func (s *Server) loadJob(ctx context.Context, id int64) (exportJob, error) {
+	job := exportJob{ID: id}
+	var errText, finished, file sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT kind, format, status, rows_done, rows_total, error,
+		strftime('%Y-%m-%dT%H:%M:%SZ', created_at), strftime('%Y-%m-%dT%H:%M:%SZ', finished_at), file
+		FROM jobs WHERE id = ?`, id).
+		Scan(&job.Kind, &job.Format, &job.Status, &job.RowsDone, &job.RowsTotal, &errText, &job.CreatedAt, &finished, &file)
+	if err != nil {
+		return job, err
+	}
+	if errText.Valid {
+		job.Error = &errText.String
+	}
+	if finished.Valid {
+		job.FinishedAt = &finished.String
+	}
+	if job.Status == jobDone && file.Valid {
+		token := signToken(exportTokenPurpose, strconv.FormatInt(id, 10), time.Now().Add(exportLinkTTL))
+		job.DownloadURL = "/admin/jobs/" + strconv.FormatInt(id, 10) + "/download?token=" + url.QueryEscape(token)
+	}
+	return job, nil
+}
+
+func jobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id must be an integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+// handleJobStatus serves GET /admin/jobs/{id}.
+func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.loadJob(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read job"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelJob serves POST /admin/jobs/{id}/cancel. The job records the
+// cancellation itself once its query stops, usually within a moment.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+	cancel, running := runningJobs.Load(id)
+	if !running {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "job is not running"})
+		return
+	}
+	cancel.(context.CancelCauseFunc)(errJobCancelled)
+	log.Printf("🛑 Export job %d cancelled by %s", id, adminActor(r))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleJobDownload serves GET /admin/jobs/{id}/download?token=. The signed
+// token stands in for the admin token, so it isn't behind adminOnly.
+func (s *Server) handleJobDownload(w http.ResponseWriter, r *http.Request) {
+	payload, err := verifyToken(exportTokenPurpose, r.URL.Query().Get("token"))
+	if err != nil || payload != r.PathValue("id") {
+		s.recordSecurityEvent(r, securityInvalidToken, "export download")
+		http.Error(w, "Invalid or expired download link", http.StatusForbidden)
+		return
+	}
+	var format string
+	var file sql.NullString
+	err = s.db.QueryRowContext(r.Context(), "SELECT format, file FROM jobs WHERE id = ? AND status = ?", payload, jobDone).Scan(&format, &file)
+	if err == sql.ErrNoRows || (err == nil && !file.Valid) {
+		http.Error(w, "Export is no longer available", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "❌ Failed to read job", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(file.String)
+	if err != nil {
+		http.Error(w, "Export is no longer available", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "❌ Failed to read export", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+payload+"."+format+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// sweepExports deletes finished files past exportRetention and any file in
+// EXPORT_DIR that neither a kept nor a running job owns.
+func (s *Server) sweepExports() {
+	rows, err := s.db.Query("SELECT id, file FROM jobs WHERE status = ? AND finished_at <= ?",
+		jobDone, sqliteTime(time.Now().Add(-exportRetention)))
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Export sweep failed", err)
+		return
+	}
+	type expired struct {
+		id   int64
+		file sql.NullString
+	}
+	var old []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.file); err != nil {
+			rows.Close()
+			s.logError(context.Background(), componentStore, "⚠️ Export sweep failed", err)
+			return
+		}
+		old = append(old, e)
+	}
+	rows.Close()
+	for _, e := range old {
+		if e.file.Valid {
+			if err := os.Remove(e.file.String); err != nil && !os.IsNotExist(err) {
+				log.Printf("⚠️ Export sweep: could not delete %s: %v", e.file.String, err)
+				continue
+			}
+		}
+		s.db.Exec("UPDATE jobs SET status = ?, file = NULL WHERE id = ?", jobExpired, e.id)
+	}
+
+	// List the directory before deciding what to keep, so a job that
+	// starts meanwhile can't lose its temp file
+	entries, err := os.ReadDir(exportDir)
+	if err != nil {
+		log.Printf("⚠️ Export sweep: could not list %s: %v", exportDir, err)
+		return
+	}
+	keep := make(map[string]bool)
+	runningJobs.Range(func(id, _ any) bool {
+		for _, format := range []string{"csv", "json"} {
+			name := exportFileName(id.(int64), format)
+			keep[name], keep[name+".tmp"] = true, true
+		}
+		return true
+	})
+	kept, err := s.db.Query("SELECT file FROM jobs WHERE status = ? AND file IS NOT NULL", jobDone)
+	if err != nil {
+		s.logError(context.Background(), componentStore, "⚠️ Export sweep failed", err)
+		return
+	}
+	for kept.Next() {
+		var file string
+		if err := kept.Scan(&file); err != nil {
+			kept.Close()
+			s.logError(context.Background(), componentStore, "⚠️ Export sweep failed", err)
+			return
+		}
+		keep[filepath.Base(file)] = true
+	}
+	kept.Close()
+
+	orphans := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), exportFilePrefix) || keep[e.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(exportDir, e.Name())); err == nil {
+			orphans++
+		}
+	}
+	if len(old) > 0 || orphans > 0 {
+		log.Printf("🧹 Export sweep: %d expired, %d orphaned files removed", len(old), orphans)
+	}
+}
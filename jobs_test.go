@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopExportJobsEndsSweep(t *testing.T) {
+	newTestServer(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	stopExportJobs(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		sweeping.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the retention sweep is still running after stopExportJobs")
+	}
+	stopExportJobs(ctx) // the test cleanup's Shutdown stops it again
+}
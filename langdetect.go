@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Deterministic, dependency-free language guess for contact messages. Script
+// decides Arabic vs Latin first; Latin text is then split into English,
+// French and Arabizi (Arabic written in Latin letters, often with digits
+// like 3, 7 and 9 standing in for Arabic sounds) by small marker lists.
+
+const (
+	langArabic  = "ar"
+	langEnglish = "en"
+	langFrench  = "fr"
+	langArabizi = "ar-Latn"
+	langUnknown = "und"
+)
+
+var (
+	englishMarkers = wordSet("the and is are you your to of for with this that have not what when how please thank thanks would like hello hi")
+	frenchMarkers  = wordSet("le la les et est vous je de des du une un pour pas que qui dans sur avec bonjour merci mon ma mes votre nous il elle ce cette")
+	arabiziMarkers = wordSet("salam slm wesh wach rani rak raki kifach kifah bzaf mlih sahit sahha khouya khoya inchallah nchallah hamdoulah hamdullah ya3tik yatik 3lik 3likom chokran choukran chkoun wallah mazal brk barka dyal ta3 lyoum ghodwa")
+)
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// detectLanguage returns a language code and a confidence in [0, 1].
+// Mixed-script text is attributed to the dominant script.
+func detectLanguage(text string) (string, float64) {
+	arabic, latin := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	total := arabic + latin
+	if total == 0 {
+		return langUnknown, 0
+	}
+	if arabic >= latin {
+		return langArabic, round2(float64(arabic) / float64(total))
+	}
+
+	scriptShare := float64(latin) / float64(total)
+	lang, markerShare := classifyLatin(text)
+	return lang, round2(scriptShare * markerShare)
+}
+
+// classifyLatin scores marker words per language; accented letters count
+// toward French and letter-digit mixes like "3lik" toward Arabizi.
+func classifyLatin(text string) (string, float64) {
+	scores := map[string]float64{langEnglish: 0, langFrench: 0, langArabizi: 0}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for _, w := range words {
+		if englishMarkers[w] {
+			scores[langEnglish]++
+		}
+		if frenchMarkers[w] {
+			scores[langFrench]++
+		}
+		if arabiziMarkers[w] {
+			scores[langArabizi] += 1.5
+		} else if isArabiziWord(w) {
+			scores[langArabizi]++
+		}
+		if strings.ContainsAny(w, "éèêàçùûôœ") {
+			scores[langFrench] += 0.5
+		}
+	}
+
+	// Fixed order keeps ties deterministic: English, French, Arabizi
+	best, bestScore, sum := langEnglish, scores[langEnglish], 0.0
+	for _, lang := range []string{langEnglish, langFrench, langArabizi} {
+		sum += scores[lang]
+		if scores[lang] > bestScore {
+			best, bestScore = lang, scores[lang]
+		}
+	}
+	if sum == 0 {
+		// Latin script with no markers at all: weak English guess
+		return langEnglish, 0.3
+	}
+	return best, bestScore / sum
+}
+
+// isArabiziWord spots letters mixed with the digits Arabizi uses for
+// Arabic sounds (2, 3, 5, 7, 9), e.g. "3andi", "sa7bi".
+func isArabiziWord(w string) bool {
+	hasLetter, hasDigit := false, false
+	for _, r := range w {
+		switch {
+		case strings.ContainsRune("23579", r):
+			hasDigit = true
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			return false
+		}
+	}
+	return hasLetter && hasDigit
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		text     string
+		lang     string
+		min, max float64
+	}{
+		{"مرحبا، أريد الاشتراك في النشرة", langArabic, 1, 1},
+		{"Hello, I would like to know how to unsubscribe please", langEnglish, 0.9, 1},
+		{"Bonjour, je voudrais savoir pourquoi je ne reçois pas la lettre", langFrench, 0.9, 1},
+		{"salam khouya, ya3tik sahha 3lik", langArabizi, 0.9, 1},
+		{"sa7bi 3andi mochkil", langArabizi, 0.9, 1},
+		// Mixed script goes to the dominant one, less sure than pure text
+		{"مرحبا بكم جميعا hello", langArabic, 0.6, 0.9},
+		{"Hello and thank you for the newsletter شكرا", langEnglish, 0.7, 0.95},
+		// Markers from two Latin languages split the confidence
+		{"merci, thank you", langEnglish, 0.6, 0.7},
+		// Latin letters without a single marker: a weak English guess
+		{"Xyzzy plugh", langEnglish, 0.3, 0.3},
+		{"", langUnknown, 0, 0},
+		{"?!... 123 :)", langUnknown, 0, 0},
+	} {
+		lang, conf := detectLanguage(tc.text)
+		if lang != tc.lang || conf < tc.min || conf > tc.max {
+			t.Errorf("detectLanguage(%q) = %s, %.2f, want %s in [%.2f, %.2f]", tc.text, lang, conf, tc.lang, tc.min, tc.max)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Deprecated: subscriber_emails.txt only exists for external scripts that
+// still tail it. The database is the source of truth; new features must not
+// read from or depend on this file (GET /view-emails reads the database).
+// It is written only when LEGACY_EMAIL_FILE=1: regenerated at startup, then
+// appended to only by the single writer goroutine below.
+
+const (
+	legacyEmailFile        = "subscriber_emails.txt"
+	legacyArchiveDir       = "legacy_archive"
+	legacyManifestFile     = "MANIFEST.sha256"
+	defaultLegacyMaxBytes  = 1 << 20
+	legacyWriterBufferSize = 100
+)
+
+var legacyEmails chan string
+
+// startLegacyFileWriter launches the writer when compatibility mode is on.
+// The file is regenerated from the database first, so an append lost to a
+// crash (or a line written for an address that has since unsubscribed)
+// doesn't survive a restart.
+func (s *Server) startLegacyFileWriter(c *Config) {
+	if !c.LegacyEmailFile {
+		return
+	}
+
+	if err := s.syncLegacyFile(); err != nil {
+		log.Println("⚠️ Failed to regenerate the legacy email file, appending to it as it is:", err)
+	}
+	legacyEmails = make(chan string, legacyWriterBufferSize)
+	go runLegacyFileWriter(legacyEmails, c.LegacyEmailFileMaxBytes)
+	log.Println("⚠️ Legacy subscriber_emails.txt compatibility mode is on (deprecated)")
+}
+
+// appendLegacyEmail queues a confirmed address for the legacy file. It is a
+// no-op when compatibility mode is off.
+func appendLegacyEmail(email string) {
+	if legacyEmails == nil {
+		return
+	}
+	select {
+	case legacyEmails <- email:
+	default:
+		log.Println("⚠️ Legacy email file writer is backed up, dropping:", email)
+	}
+}
+
+func runLegacyFileWriter(emails <-chan string, maxBytes int64) {
+	for email := range emails {
+		if err := writeLegacyEmail(email, maxBytes); err != nil {
+			log.Println("⚠️ Failed to write email to legacy file:", err)
+		}
+	}
+}
+
+func writeLegacyEmail(email string, maxBytes int64) error {
+	if info, err := os.Stat(legacyEmailFile); err == nil && info.Size() >= maxBytes {
+		if err := rotateLegacyFile(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(legacyEmailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(email + "\n")
+	return err
+}
+
+// rotateLegacyFile renames the current file into a dated archive (rename is
+// atomic, so tailing scripts see either the old or a fresh file) and records
+// its checksum in the archive manifest.
+func rotateLegacyFile() error {
+	if err := os.MkdirAll(legacyArchiveDir, 0755); err != nil {
+		return err
+	}
+
+	name := "subscriber_emails-" + time.Now().UTC().Format("20060102-150405") + ".txt"
+	archived := filepath.Join(legacyArchiveDir, name)
+	if err := os.Rename(legacyEmailFile, archived); err != nil {
+		return err
+	}
+
+	sum, err := fileSHA256(archived)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := os.OpenFile(filepath.Join(legacyArchiveDir, legacyManifestFile),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+
+	_, err = fmt.Fprintf(manifest, "%s  %s\n", sum, name)
+	if err == nil {
+		log.Println("🗄️ Rotated legacy email file to", archived)
+	}
+	return err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// syncLegacyFile regenerates subscriber_emails.txt from the verified
+// subscribers in the database, replacing the old file atomically. The writer
+// only ever appends, so this is also how unsubscribed addresses get dropped.
+func (s *Server) syncLegacyFile() error {
+	rows, err := s.db.Query("SELECT email FROM subscribers WHERE verified = 1 AND unsubscribed_at IS NULL ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tmp, err := os.CreateTemp(".", legacyEmailFile+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	count := 0
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := tmp.WriteString(email + "\n"); err != nil {
+			tmp.Close()
+			return err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), legacyEmailFile); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Regenerated %s with %d verified subscribers", legacyEmailFile, count)
+	return nil
+}
@@ -1,28 +1,32 @@
 package main
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
+	"mime"
+	"mime/quotedprintable"
 	"net/http"
-	"net/smtp"
 	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "modernc.org/sqlite"
 
-	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
-	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/facebook"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/google"
 	"golang.org/x/net/context"
 )
 
-var db *sql.DB
-
 func main() {
 	err := godotenv.Load() // Load .env environment variables
 
@@ -30,261 +34,479 @@ func main() {
 		log.Println("⚠️ .env not loaded, using system env")
 	}
 
-	// Set SESSION_SECRET for Goth
-	key := os.Getenv("SESSION_SECRET")
-	if key == "" {
-		log.Fatal("❌ SESSION_SECRET is missing in .env")
-	}
-	log.Println("✅ SESSION_SECRET loaded successfully!")
-	// 30 days
-
-	store := sessions.NewCookieStore([]byte(key))
-	store.MaxAge(86400 * 30)
-	store.Options.Path = "/"
-	store.Options.HttpOnly = true
-	store.Options.Secure = false
-	gothic.Store = store
-
-	// Set up Goth with providers
-	goth.UseProviders(
-		facebook.New(
-			os.Getenv("FACEBOOK_KEY"),
-			os.Getenv("FACEBOOK_SECRET"),
-			"http://localhost:8080/auth/facebook/callback",
-		),
-		google.New(
-			os.Getenv("GOOGLE_KEY"),
-			os.Getenv("GOOGLE_SECRET"),
-			"http://localhost:8080/auth/google/callback",
-			"email", "profile",
-		),
-		github.New(
-			os.Getenv("GITHUB_KEY"),
-			os.Getenv("GITHUB_SECRET"),
-			"http://localhost:8080/auth/github/callback",
-		),
-	)
-
-	db, err = sql.Open("sqlite", "./subscribe/DB_subscribers.db")
+	cfg, err := LoadConfig()
 	if err != nil {
-		log.Fatal("❌ DB connection failed:", err)
+		log.Fatalf("❌ Invalid configuration:\n%v", err)
+	}
+	configureLogging(cfg)
+
+	// Maintenance subcommands (e.g. `sync-legacy-file`) run and exit
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1], os.Args[2:])
+		return
+	}
+
+	s := NewServer(cfg)
+	watchSettingsReload()
+
+	srv := &http.Server{Addr: ":8080", Handler: s.Routes()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		log.Println("🌐 Server started at http://localhost:8080")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal("❌ Server failed: ", err)
+		}
+	}()
+	<-ctx.Done()
+	// A second signal kills the process without waiting
+	stop()
+
+	// Drain requests first, since they may still queue mail; then let
+	// broadcasts and sends in progress finish. Anything still queued stays
+	// in the table. The database closes last.
+	log.Printf("🛑 Shutting down (deadline %s)", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	stopControlSocket()
+	log.Println("🛑 Refusing new connections, waiting for in-flight requests")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("⚠️ Requests still open at the shutdown deadline were cut off:", err)
 	}
-	defer db.Close()
-	createTables()
-
-	// http.Handle("/",
-	fs := http.FileServer(http.Dir("./static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	http.HandleFunc("/", serveIndex)
-	http.HandleFunc("/subscribe", serveSubscribe)
-	http.HandleFunc("/subscriber/email", handleEmailSubscription)
-	http.HandleFunc("/verify", handleEmailVerification)
-	http.HandleFunc("/subscribers", handleListSubscribers)
-	http.HandleFunc("/view-emails", handleViewEmails)
-	http.HandleFunc("/submit", handleFormSubmission)
-
-	http.HandleFunc("/auth/facebook", handleOAuthLogin("facebook"))
-	http.HandleFunc("/auth/facebook/callback", handleOAuthCallback("facebook"))
-	http.HandleFunc("/auth/google", handleOAuthLogin("google"))
-	http.HandleFunc("/auth/google/callback", handleOAuthCallback("google"))
-	http.HandleFunc("/auth/github", handleOAuthLogin("github"))
-	http.HandleFunc("/auth/github/callback", handleOAuthCallback("github"))
-
-	log.Println("🌐 Server started at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	s.Shutdown(shutdownCtx)
+	log.Println("👋 Shutdown complete")
 }
 
-// ✅ This function is now outside of main
-func createTables() {
-	// Make sure db is initialized and open
-	if db == nil {
-		log.Fatal("❌ DB is not initialized")
-	}
-
-	subscriberTable := `
-	CREATE TABLE IF NOT EXISTS subscribers (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		email TEXT NOT NULL UNIQUE,
-		verified BOOLEAN DEFAULT 0
-	);`
-
-	messageTable := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		subscriber_id INTEGER,
-		message TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
-	);`
-
-	_, err := db.Exec(subscriberTable)
-	if err != nil {
-		log.Fatalf("❌ Failed to create subscribers table: %v", err)
+const defaultDatabasePath = "./subscribe/DB_subscribers.db"
+
+// openDB opens DATABASE_PATH or DATABASE_URL (default
+// ./subscribe/DB_subscribers.db) and migrates it. DATABASE_PATH=:memory:
+// gives a throwaway database for tests and demos; it uses a shared cache so
+// every pooled connection sees the same data.
+func openDB(path string) *sql.DB {
+
+	// Concurrent senders write from several connections; wait for a lock
+	// instead of failing with SQLITE_BUSY. WAL lets writers go ahead while
+	// a long read (an export) is still open, instead of waiting it out.
+	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	if path == ":memory:" {
+		dsn = "file::memory:?cache=shared&_pragma=busy_timeout(5000)"
 	}
 
-	_, err = db.Exec(messageTable)
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		log.Fatalf("❌ Failed to create messages table: %v", err)
+		log.Fatal("❌ DB connection failed:", err)
 	}
+	if path == ":memory:" {
+		// The database vanishes when its last connection closes, so never
+		// let the pool retire idle connections.
+		db.SetConnMaxIdleTime(0)
+		db.SetConnMaxLifetime(0)
+		db.SetMaxIdleConns(4)
+	}
+	runMigrations(db)
+	return db
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./static/index.html")
 }
 
-func serveSubscribe(w http.ResponseWriter, r *http.Request) {
+func (s *Server) serveSubscribe(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	http.ServeFile(w, r, "./static/subscribe.html")
+	s.recordFormView(r.Context())
+	// Parsed per request, like ServeFile, so edits show up without a restart
+	page, err := parseSubscribePage()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to load the subscribe page: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, subscribePageData(csrfField(r))); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
+
+func parseSubscribePage() (*template.Template, error) {
+	return template.New("subscribe.html").Option("missingkey=error").ParseFiles("./static/subscribe.html")
 }
 
-func handleEmailSubscription(w http.ResponseWriter, r *http.Request) {
+// subscribePageData is what static/subscribe.html sees.
+func subscribePageData(csrf template.HTML) map[string]any {
+	return map[string]any{"csrfField": csrf}
+}
+
+var subscribePageSample = subscribePageData(`<input type="hidden" name="csrf_token" value="sample">`)
+
+func (s *Server) handleEmailSubscription(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Invalid method")
 		return
 	}
 
-	email := r.FormValue("email")
-	if email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	if err := parseLimitedForm(w, r); err != nil {
+		s.writeFormError(w, r, err)
 		return
 	}
-
-	// Insert or ignore subscriber
-	_, err := db.Exec("INSERT OR IGNORE INTO subscribers(email) VALUES(?)", email)
+	email, err := emailValue(r, "email")
+	if err == nil && emailDomainBlocked(email, s.cfg.BlockedEmailDomains) {
+		err = &formError{Field: "email", Problem: "uses a domain we don't accept; please use another address"}
+	}
 	if err != nil {
-		http.Error(w, "❌ Could not save email: "+err.Error(), http.StatusInternalServerError)
+		s.writeFormError(w, r, err)
 		return
 	}
 
-	// Get subscriber ID (in case we need it later)
-	var id int
-	err = db.QueryRow("SELECT id FROM subscribers WHERE email = ?", email).Scan(&id)
+	// The signup, its verification token and the queued confirmation are
+	// written in one transaction, so a failure leaves no half-made row.
+	// A fresh token replaces any earlier one, so only the newest link works.
+	var link string
+	var emailID int64
+	sub, err := s.store.AddSubscriber(r.Context(), email, func(q dbtx, sub subscriberRow) error {
+		if sub.Verified {
+			return nil
+		}
+		token, err := issueVerificationToken(r.Context(), q, sub.ID)
+		if err != nil {
+			return fmt.Errorf("create verification link: %w", err)
+		}
+		link = verificationLink(token)
+		data := confirmationData{Recipient: email, VerifyLink: link, SiteName: siteName}
+		emailID, err = queueConfirmationEmail(r.Context(), q, sub.ID, "confirmation", data)
+		return err
+	})
+	if err != nil && emailID != 0 {
+		// Queued, but the commit failed
+		releaseEmailSlot()
+	}
+	if errors.Is(err, errEmailQueueFull) {
+		s.writeRetryLater(w, r, http.StatusServiceUnavailable, emailQueueRetryAfter(), "⚠️ We're busy right now, please try again shortly")
+		return
+	}
 	if err != nil {
-		http.Error(w, "❌ Could not retrieve subscriber ID: "+err.Error(), http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Could not save email: "+err.Error())
 		return
 	}
+	id := sub.ID
+	s.recordFunnelEvent(r.Context(), id, stageSubmitted)
 
-	// Append to file
-	f, err := os.OpenFile("subscriber_emails.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err == nil {
-		defer f.Close()
-		_, _ = f.WriteString(email + "\n")
-	} else {
-		log.Println("⚠️ Failed to write email to file:", err)
+	if sub.Verified {
+		respond(w, r, http.StatusOK, "✅ You are already subscribed. Thank you!",
+			map[string]string{"status": "already_subscribed", "email": email})
+		log.Println("📥 Repeat subscription for verified address:", email)
+		return
 	}
+	dispatchQueuedEmail(emailID)
+	s.recordFunnelEvent(r.Context(), id, stageConfirmationSent)
+	subscriptionsTotal.Add(1)
 
-	// Generate verification link
-	link := "http://localhost:8080/verify?email=" + url.QueryEscape(email)
-	sendConfirmationEmail(email, link)
-
-	// Respond to browser
-	fmt.Fprintf(w, "✅ Message received! Thank you.")
+	// Respond to browser; the email itself goes out from the queue
+	respond(w, r, http.StatusAccepted, "✅ Message received! Thank you.",
+		map[string]string{"status": "verification_sent", "email": email})
 
 	// Console log for developer
 	log.Println("📥 Subscription received for:", email)
 	fmt.Println("🔗 Verification link:", link)
 }
 
-func sendConfirmationEmail(to string, link string) {
-	from := os.Getenv("EMAIL_ADDRESS")
-	password := os.Getenv("EMAIL_PASSWORD")
+// queueConfirmationEmail renders the named template pair, takes a queue
+// slot and stores the message through q, returning the id to pass to
+// dispatchQueuedEmail once q has committed. The worker records the
+// "delivered" funnel stage once SMTP accepts it.
+func queueConfirmationEmail(ctx context.Context, q dbtx, subscriberID int, name string, data confirmationData) (int64, error) {
+	subject, text, html, err := renderEmail(name, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := takeEmailSlot(); err != nil {
+		return 0, err
+	}
+	id, err := insertPendingEmail(ctx, q, emailKindConfirmation, subscriberID, data.Recipient, subject, text, html, nil)
+	if err != nil {
+		releaseEmailSlot()
+		return 0, err
+	}
+	return id, nil
+}
+
+// sendEmail delivers a plain-text message; see sendMessage.
+func (s *Server) sendEmail(to, subject, body string, extraHeaders map[string]string) error {
+	return s.sendMessage(to, subject, body, "", extraHeaders)
+}
 
-	if from == "" || password == "" {
+// sendMessage delivers a UTF-8 message through the configured SMTP server
+// (see smtp.go). With html set it goes out as multipart/alternative.
+// extraHeaders are added verbatim after the standard headers. Mail to a
+// subscriber always carries their unsubscribe link.
+func (s *Server) sendMessage(to, subject, text, html string, extraHeaders map[string]string) error {
+	cfg := currentSettings()
+	sender, from, password := s.senderAddress(cfg), s.fromHeader(cfg), cfg.EmailPassword
+
+	switch {
+	case simulation.enabled:
+		// Staging needs no real credentials
+		if sender == "" {
+			sender, from = previewSender, previewSender
+		}
+	case sender == "" || (password == "" && s.smtp.auth != smtpAuthNone):
 		log.Println("❌ EMAIL_ADDRESS or EMAIL_PASSWORD is not set in .env")
-		return
+		return errors.New("email credentials not configured")
 	}
 
-	subject := "Please verify your email"
-	body := fmt.Sprintf("Hello,\n\nPlease click the link below to confirm your subscription:\n\n%s\n\nThanks!", link)
+	unsubscribe, err := s.subscriberUnsubscribeLink(to)
+	if err != nil {
+		return err
+	}
+	if unsubscribe != "" {
+		text, extraHeaders = withUnsubscribe(text, extraHeaders, unsubscribe)
+		html = withUnsubscribeHTML(html, unsubscribe)
+	}
 
-	// Full message with CRLF line endings (for better SMTP compliance)
-	msg := []byte("From: " + from + "\r\n" +
-		"To: " + to + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-Version: 1.0\r\n" +
-		"Content-Type: text/plain; charset=\"UTF-8\"\r\n" +
-		"\r\n" +
-		body + "\r\n")
-
-	// Send the email using Gmail's SMTP
-	err := smtp.SendMail(
-		"smtp.gmail.com:587",
-		smtp.PlainAuth("", from, password, "smtp.gmail.com"),
-		from,
-		[]string{to},
-		msg,
-	)
+	msg := buildMessage(from, to, subject, text, html, time.Now(), newMessageID(sender), extraHeaders)
 
+	if simulation.enabled {
+		err = s.simulateSend(to, subject, msg)
+	} else {
+		err = s.smtpDeliver(sender, to, cfg.EmailAddress, password, msg)
+	}
+	recordSendOutcome(err == nil)
 	if err != nil {
-		log.Println("❌ Email send failed:", err)
+		emailSendErrorsTotal.Add(1)
+		s.logError(context.Background(), componentMail, "❌ Email send failed", err, "to", to)
+		return err
+	}
+	return nil
+}
+
+// buildMessage produces the exact bytes handed to SMTP, an RFC 5322 message
+// with CRLF line endings. Extra headers are written in sorted order so the
+// output is stable for a given input.
+func buildMessage(from, to, subject, text, html string, date time.Time, messageID string, extraHeaders map[string]string) []byte {
+	headers := "Date: " + date.Format(time.RFC1123Z) + "\r\n" +
+		"Message-ID: " + messageID + "\r\n" +
+		"From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("UTF-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n"
+
+	var body string
+	if html == "" {
+		headers += "Content-Type: text/plain; charset=\"UTF-8\"\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n"
+		body = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n") + "\r\n"
 	} else {
-		log.Println("✅ Confirmation email sent to:", to)
-		log.Println("🔗 Verification link:", link) // Log the link for development/debug
+		// "=_" can never occur in quoted-printable output, so the boundary
+		// is safe; deriving it from the content keeps previews stable.
+		sum := sha256.Sum256([]byte(text + html))
+		boundary := "=_" + hex.EncodeToString(sum[:12])
+		headers += "Content-Type: multipart/alternative; boundary=\"" + boundary + "\"\r\n"
+		body = "--" + boundary + "\r\n" + quotedPrintablePart("text/plain", text) +
+			"--" + boundary + "\r\n" + quotedPrintablePart("text/html", html) +
+			"--" + boundary + "--\r\n"
 	}
+
+	keys := make([]string, 0, len(extraHeaders))
+	for k := range extraHeaders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		headers += k + ": " + extraHeaders[k] + "\r\n"
+	}
+
+	return []byte(headers + "\r\n" + body)
+}
+
+// quotedPrintablePart encodes one UTF-8 body part with its headers.
+func quotedPrintablePart(contentType, content string) string {
+	var b strings.Builder
+	b.WriteString("Content-Type: " + contentType + "; charset=\"UTF-8\"\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(content, "\r\n", "\n")))
+	qp.Close()
+	b.WriteString("\r\n")
+	return b.String()
 }
 
 // ✅ New handler to verify email
-func handleEmailVerification(w http.ResponseWriter, r *http.Request) {
-	email := r.URL.Query().Get("email")
-	if email == "" {
-		http.Error(w, "Missing email in verification link", http.StatusBadRequest)
+func (s *Server) handleEmailVerification(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		renderMessagePage(w, http.StatusBadRequest, messagePageData{
+			Title:       "Invalid link",
+			Message:     "This verification link is missing its token. Please use the link from your email.",
+			ArabicTitle: "رابط غير صالح", ArabicMessage: "رابط التأكيد ناقص. يرجى استخدام الرابط الموجود في بريدك الإلكتروني.",
+		})
+		return
+	}
+
+	sub, err := s.store.GetByVerificationToken(r.Context(), hashToken(token))
+	if err == errNotFound {
+		renderMessagePage(w, http.StatusNotFound, messagePageData{
+			Title:       "Link not recognized",
+			Message:     "This verification link is not valid, or a newer one has been sent. Please use the latest email or subscribe again.",
+			ArabicTitle: "رابط غير معروف", ArabicMessage: "رابط التأكيد غير صالح أو تم إرسال رابط أحدث. يرجى استخدام آخر رسالة أو الاشتراك من جديد.",
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "❌ Failed to look up subscriber: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.recordFunnelEvent(r.Context(), sub.ID, stageLinkClicked)
+
+	// Tokens stay on the row after use, so a second click lands here
+	if sub.Verified {
+		renderMessagePage(w, http.StatusOK, messagePageData{
+			Title:       "Already verified",
+			Message:     sub.Email + " is already verified. Nothing more to do!",
+			ArabicTitle: "تم التأكيد مسبقاً", ArabicMessage: "تم تأكيد هذا العنوان مسبقاً، لا حاجة لأي إجراء آخر.",
+		})
+		return
+	}
+	if sub.tokenExpired(time.Now()) {
+		renderMessagePage(w, http.StatusGone, messagePageData{
+			Title:       "Link expired",
+			Message:     "This verification link has expired. Please subscribe again to get a new one.",
+			ArabicTitle: "انتهت صلاحية الرابط", ArabicMessage: "انتهت صلاحية رابط التأكيد. يرجى الاشتراك من جديد للحصول على رابط جديد.",
+		})
 		return
 	}
 
 	// ✅ Update the 'verified' field to true (1)
-	_, err := db.Exec("UPDATE subscribers SET verified = 1 WHERE email = ?", email)
+	changed, err := s.store.MarkVerified(r.Context(), sub.ID)
 	if err != nil {
 		http.Error(w, "❌ Failed to verify email: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if changed {
+		s.recordFunnelEvent(r.Context(), sub.ID, stageVerified)
+		appendLegacyEmail(sub.Email)
+	}
+
+	renderMessagePage(w, http.StatusOK, messagePageData{
+		Title:       "Subscription confirmed",
+		Message:     "Thank you " + sub.Email + ", your email is now verified!",
+		ArabicTitle: "تم تأكيد الاشتراك", ArabicMessage: "شكراً لك، تم تأكيد بريدك الإلكتروني بنجاح!",
+	})
+}
 
-	fmt.Fprintf(w, "✅ Thank you %s, your email is now verified!", email)
+// verificationLink builds the link sent in confirmation emails.
+func verificationLink(token string) string {
+	return siteLink("/verify", url.Values{"token": {token}})
 }
 
-func handleListSubscribers(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT email FROM subscribers WHERE Verified = 1")
+// handleListSubscribers lists subscribers with their status;
+// ?verified=true or ?verified=false narrows the list. Unsubscribed
+// addresses are left out unless ?include_unsubscribed=true. Accept:
+// application/json gets {"subscribers": [{"email", "status"}]}.
+//
+// Deprecated: kept for old scripts; use the paginated /api/v1/subscribers.
+func (s *Server) handleListSubscribers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", `</api/v1/subscribers>; rel="successor-version"`)
+	var f subscriberFilter
+	if v := r.URL.Query().Get("verified"); v != "" {
+		want, err := strconv.ParseBool(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "verified must be true or false")
+			return
+		}
+		f.Verified = &want
+	}
+	if v := r.URL.Query().Get("include_unsubscribed"); v != "" {
+		var err error
+		if f.IncludeUnsubscribed, err = strconv.ParseBool(v); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "include_unsubscribed must be true or false")
+			return
+		}
+	}
+
+	subs, err := s.store.List(r.Context(), f)
 	if err != nil {
-		http.Error(w, "Failed to fetch subscribers", http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "Failed to fetch subscribers: "+err.Error())
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var email string
-		rows.Scan(&email)
-		fmt.Fprintln(w, email)
+	type listed struct {
+		Email  string `json:"email"`
+		Status string `json:"status"`
+	}
+	out := make([]listed, 0, len(subs))
+	for _, sub := range subs {
+		status := "unverified"
+		switch {
+		case sub.Unsubscribed:
+			status = "unsubscribed"
+		case sub.Verified:
+			status = "verified"
+		}
+		out = append(out, listed{sub.Email, status})
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]any{"subscribers": out})
+		return
+	}
+	for _, sub := range out {
+		fmt.Fprintf(w, "%s\t%s\n", sub.Email, sub.Status)
 	}
 }
 
-func handleViewEmails(w http.ResponseWriter, r *http.Request) {
-	data, err := os.ReadFile("subscriber_emails.txt")
+// handleViewEmails lists the verified, still-subscribed addresses one per
+// line, the same content as the legacy file but read from the database, so
+// it is right whether or not LEGACY_EMAIL_FILE is on.
+func (s *Server) handleViewEmails(w http.ResponseWriter, r *http.Request) {
+	verified := true
+	subs, err := s.store.List(r.Context(), subscriberFilter{Verified: &verified})
 	if err != nil {
-		http.Error(w, "❌ Cannot read file", http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to fetch subscribers: "+err.Error())
 		return
 	}
-	w.Write(data)
+
+	var b strings.Builder
+	for _, sub := range subs {
+		b.WriteString(sub.Email + "\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
 }
 
-func handleFormSubmission(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleFormSubmission(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		r.ParseForm()
-		email := r.FormValue("email")
-		message := r.FormValue("message")
-
-		if email == "" || message == "" {
-			http.Error(w, "Email and message are required", http.StatusBadRequest)
+		if err := parseLimitedForm(w, r); err != nil {
+			s.writeFormError(w, r, err)
+			return
+		}
+		email, err := emailValue(r, "email")
+		if err != nil {
+			s.writeFormError(w, r, err)
+			return
+		}
+		message, err := formValue(r, "message", maxMessageRunes, true, true)
+		if err != nil {
+			s.writeFormError(w, r, err)
 			return
 		}
 
 		fmt.Printf("📩 New message from %s: %s\n", email, message)
 
-		w.Write([]byte("✅ Message received!"))
+		messageID, err := s.store.AddMessage(r.Context(), email, message)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, "❌ Could not save message: "+err.Error())
+			return
+		}
+		if autoReplyEnabled() {
+			go s.sendAutoReply(messageID, email, message)
+		}
+
+		respond(w, r, http.StatusOK, "✅ Message received!", map[string]any{"status": "received", "id": messageID})
 	} else {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Invalid method")
 	}
 }
 
@@ -297,17 +519,31 @@ func handleOAuthLogin(provider string) http.HandlerFunc {
 	}
 }
 
-func handleOAuthCallback(provider string) http.HandlerFunc {
+func (s *Server) handleOAuthCallback(provider string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r = r.WithContext(context.WithValue(r.Context(), gothic.ProviderParamKey, provider))
 		user, err := gothic.CompleteUserAuth(w, r)
 		if err != nil {
+			s.recordSecurityEvent(r, securityOAuthFailed, provider+": "+err.Error())
+			s.logError(r.Context(), componentOAuth, "❌ OAuth login failed", err, "provider", provider)
 			http.Error(w, provider+" login failed: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, "✅ Logged in via %s\nName: %s\nEmail: %s", provider, user.Name, user.Email)
 
-		log.Println("🌐 Server started at http://localhost:8080")
+		userID, err := s.recordLogin(r.Context(), user)
+		if err != nil {
+			s.logError(r.Context(), componentOAuth, "❌ Could not save user", err, "provider", provider)
+			http.Error(w, "❌ Could not save user: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.startUserSession(w, r, userID); err != nil {
+			s.logError(r.Context(), componentOAuth, "❌ Could not start session", err, "provider", provider)
+			http.Error(w, "❌ Could not start session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, "✅ Logged in via %s\nName: %s\nEmail: %s", provider, user.Name, user.Email)
+		log.Printf("🔐 Login via %s: user %d (%s)", provider, userID, user.Email)
 	}
 
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "test-admin-token"
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// newTestServer starts a Server on DATABASE_PATH=:memory: with simulated
+// mail, so nothing touches disk or the network; env adds to or overrides
+// the defaults. The server is shut down when the test ends, which closes
+// the last connection and drops the database, so every test starts empty.
+func newTestServer(t testing.TB, env map[string]string) (*Server, *httptest.Server) {
+	t.Helper()
+	vars := map[string]string{
+		"SESSION_SECRET":   "test-session-secret",
+		"ADMIN_TOKEN":      testAdminToken,
+		"DATABASE_PATH":    ":memory:",
+		"MAIL_TRANSPORT":   transportSimulate,
+		"SIMULATE_LATENCY": "0s",
+		"EXPORT_DIR":       t.TempDir(),
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	cfg, err := configFromEnv(func(k string) string { return vars[k] })
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	s := NewServer(cfg)
+	ts := httptest.NewServer(s.Routes())
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s, ts
+}
+
+// do sends a request to ts and returns the response with its body read.
+// A non-nil body is sent as JSON; headers are name, value pairs.
+func do(t *testing.T, ts *httptest.Server, method, path string, body any, headers ...string) (*http.Response, string) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, path, err)
+	}
+	return resp, string(b)
+}
+
+func subscribe(t *testing.T, ts *httptest.Server, email string) (*http.Response, map[string]string) {
+	t.Helper()
+	resp, body := do(t, ts, http.MethodPost, "/subscriber/email", map[string]string{"email": email})
+	var out map[string]string
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("subscribe %s: %d %q is not JSON", email, resp.StatusCode, body)
+	}
+	return resp, out
+}
+
+type listedSubscriber struct {
+	Email  string `json:"email"`
+	Status string `json:"status"`
+}
+
+func listSubscribers(t *testing.T, ts *httptest.Server) []listedSubscriber {
+	t.Helper()
+	resp, body := do(t, ts, http.MethodGet, "/subscribers?include_unsubscribed=true", nil,
+		"Authorization", "Bearer "+testAdminToken, "Accept", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /subscribers = %d %q", resp.StatusCode, body)
+	}
+	var out struct {
+		Subscribers []listedSubscriber `json:"subscribers"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("GET /subscribers: %v in %q", err, body)
+	}
+	return out.Subscribers
+}
+
+var verifyTokenPattern = regexp.MustCompile(`/verify\?token=([^\s"&<]+)`)
+
+// verificationToken reads the token out of the newest confirmation email
+// queued for email; only its hash is kept on the subscriber row.
+func verificationToken(t *testing.T, s *Server, email string) string {
+	t.Helper()
+	var body string
+	err := s.db.QueryRow("SELECT body FROM pending_emails WHERE recipient = ? AND kind = ? ORDER BY id DESC LIMIT 1",
+		email, emailKindConfirmation).Scan(&body)
+	if err != nil {
+		t.Fatalf("confirmation email for %s: %v", email, err)
+	}
+	m := verifyTokenPattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no verification link in %q", body)
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSubscribeListVerify(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+
+	resp, out := subscribe(t, ts, "Reader@Example.COM")
+	if resp.StatusCode != http.StatusAccepted || out["status"] != "verification_sent" {
+		t.Fatalf("subscribe = %d %v, want 202 verification_sent", resp.StatusCode, out)
+	}
+	// The domain and ASCII letters of the local part are lowercased
+	if out["email"] != "reader@example.com" {
+		t.Errorf("subscribed as %q, want reader@example.com", out["email"])
+	}
+
+	got := listSubscribers(t, ts)
+	if len(got) != 1 || got[0] != (listedSubscriber{"reader@example.com", "unverified"}) {
+		t.Fatalf("after subscribe, list = %v", got)
+	}
+
+	token := verificationToken(t, s, "reader@example.com")
+	resp, body := do(t, ts, http.MethodGet, "/verify?token="+url.QueryEscape(token), nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "now verified") {
+		t.Fatalf("verify = %d %q", resp.StatusCode, body)
+	}
+
+	got = listSubscribers(t, ts)
+	if len(got) != 1 || got[0].Status != "verified" {
+		t.Fatalf("after verify, list = %v", got)
+	}
+
+	// A second click on the link and a repeat signup change nothing
+	resp, body = do(t, ts, http.MethodGet, "/verify?token="+url.QueryEscape(token), nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "already verified") {
+		t.Errorf("second verify = %d %q", resp.StatusCode, body)
+	}
+	resp, out = subscribe(t, ts, "reader@example.com")
+	if resp.StatusCode != http.StatusOK || out["status"] != "already_subscribed" {
+		t.Errorf("repeat subscribe = %d %v, want 200 already_subscribed", resp.StatusCode, out)
+	}
+	if got := listSubscribers(t, ts); len(got) != 1 {
+		t.Errorf("repeat subscribe added a row: %v", got)
+	}
+}
+
+func TestSubscribeReplacesVerificationLink(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+
+	subscribe(t, ts, "reader@example.com")
+	first := verificationToken(t, s, "reader@example.com")
+	subscribe(t, ts, "reader@example.com")
+	second := verificationToken(t, s, "reader@example.com")
+	if first == second {
+		t.Fatal("resubscribing reused the verification token")
+	}
+
+	resp, _ := do(t, ts, http.MethodGet, "/verify?token="+url.QueryEscape(first), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("superseded link = %d, want 404", resp.StatusCode)
+	}
+	resp, _ = do(t, ts, http.MethodGet, "/verify?token="+url.QueryEscape(second), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("newest link = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestSubscribeRejectsInvalidEmail(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"EMAIL_BLACKLIST_DOMAINS": "mailinator.com"})
+
+	for _, email := range []string{"", "not-an-address", "Name <reader@example.com>", "reader@localhost", "reader@mailinator.com"} {
+		resp, out := subscribe(t, ts, email)
+		if resp.StatusCode != http.StatusBadRequest || out["error"] == "" {
+			t.Errorf("subscribe %q = %d %v, want 400 with an error", email, resp.StatusCode, out)
+		}
+	}
+	if got := listSubscribers(t, ts); len(got) != 0 {
+		t.Errorf("rejected signups were saved: %v", got)
+	}
+}
+
+var csrfInputPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// The browser path: the form page sets the CSRF cookie and embeds the token.
+func TestSubscribeForm(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	resp, page := do(t, ts, http.MethodGet, "/subscribe", nil)
+	m := csrfInputPattern.FindStringSubmatch(page)
+	if resp.StatusCode != http.StatusOK || m == nil {
+		t.Fatalf("GET /subscribe = %d, token found: %v", resp.StatusCode, m != nil)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == csrfCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("GET /subscribe set no CSRF cookie")
+	}
+
+	post := func(form url.Values) int {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/subscriber/email", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(url.Values{"email": {"reader@example.com"}}); code != http.StatusForbidden {
+		t.Errorf("form without a CSRF token = %d, want 403", code)
+	}
+	if code := post(url.Values{"email": {"reader@example.com"}, "csrf_token": {m[1]}}); code != http.StatusAccepted {
+		t.Errorf("form with the page's CSRF token = %d, want 202", code)
+	}
+	if got := listSubscribers(t, ts); len(got) != 1 {
+		t.Errorf("list = %v, want the one form signup", got)
+	}
+}
+
+func TestVerifyBadLinks(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	for path, want := range map[string]int{
+		"/verify":               http.StatusBadRequest,
+		"/verify?token=":        http.StatusBadRequest,
+		"/verify?token=unknown": http.StatusNotFound,
+	} {
+		if resp, _ := do(t, ts, http.MethodGet, path, nil); resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestVerifyExpiredLink(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+
+	subscribe(t, ts, "reader@example.com")
+	token := verificationToken(t, s, "reader@example.com")
+	if _, err := s.db.Exec("UPDATE subscribers SET verification_expires_at = ?", time.Now().Add(-time.Minute).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := do(t, ts, http.MethodGet, "/verify?token="+url.QueryEscape(token), nil); resp.StatusCode != http.StatusGone {
+		t.Errorf("expired link = %d, want 410", resp.StatusCode)
+	}
+	if got := listSubscribers(t, ts); len(got) != 1 || got[0].Status != "unverified" {
+		t.Errorf("expired link changed the row: %v", got)
+	}
+}
+
+// TestBuildMessageGolden pins the exact bytes of the preview emails, which
+// run every template through buildMessage with fixed data. After a
+// deliberate change to a template or to buildMessage, run
+// go test -run TestBuildMessageGolden -update and review the diff.
+func TestBuildMessageGolden(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+
+	for _, tc := range []struct{ golden, name, lang string }{
+		{"confirmation.eml", "confirmation", ""},
+		{"auto_reply_ar.eml", "auto_reply", "ar"},
+	} {
+		msg, ok, err := s.previewEmail(tc.name, tc.lang)
+		if !ok || err != nil {
+			t.Fatalf("preview %s: %v", tc.name, err)
+		}
+		path := filepath.Join("testdata", tc.golden)
+		if *updateGolden {
+			if err := os.WriteFile(path, msg, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v (run with -update to create it)", err)
+		}
+		if !bytes.Equal(msg, want) {
+			t.Errorf("%s differs from %s:\n%s", tc.name, path, msg)
+		}
+	}
+}
+
+func TestBuildMessageMultipart(t *testing.T) {
+	text := "مرحباً\r\nA line with = and trailing space \n" + strings.Repeat("long ", 40)
+	html := `<p dir="rtl">مرحباً <a href="https://example.com/?a=1&b=2">link</a></p>`
+	msg := buildMessage("News <news@example.com>", "reader@example.com", "نشرة اليوم: Today's news", text, html,
+		previewTime, previewMessageID, map[string]string{"List-Unsubscribe": "<" + previewUnsubLink + ">"})
+
+	if bytes.Contains(bytes.ReplaceAll(msg, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("message has a bare LF")
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); err != nil || subject != "نشرة اليوم: Today's news" {
+		t.Errorf("Subject decodes to %q, %v", subject, err)
+	}
+	if got := m.Header.Get("List-Unsubscribe"); got != "<"+previewUnsubLink+">" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q", m.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(m.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain", strings.ReplaceAll(text, "\r\n", "\n")},
+		{"text/html", html},
+	} {
+		p, err := parts.NextPart() // undoes the quoted-printable encoding
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Header.Get("Content-Type"); got != want.contentType+`; charset="UTF-8"` {
+			t.Errorf("part Content-Type = %q, want %s", got, want.contentType)
+		}
+		body, _ := io.ReadAll(p)
+		if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != want.body {
+			t.Errorf("%s part decodes to %q, want %q", want.contentType, got, want.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("after two parts: %v, want the closing boundary", err)
+	}
+}
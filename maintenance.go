@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Maintenance mode answers public pages with 503 while leaving the admin
+// endpoints, /status and static files reachable. It is toggled from the
+// control socket and is not persisted: a restart turns it off.
+//
+// Turning it on announces a window ("maintenance on 30m", default 5m), and
+// Retry-After counts down to its end. If the work overruns, the mode stays
+// on and clients are asked to come back every maintenanceOverrunRetry until
+// it is turned off.
+
+const (
+	defaultMaintenanceWindow = 5 * time.Minute
+	maxMaintenanceWindow     = 24 * time.Hour
+	maintenanceOverrunRetry  = time.Minute
+)
+
+var (
+	maintenanceMode atomic.Bool
+	maintenanceEnds atomic.Int64 // UnixNano
+)
+
+// startMaintenance turns maintenance mode on for window and returns when
+// it is expected to end.
+func startMaintenance(window time.Duration) time.Time {
+	ends := time.Now().Add(window)
+	maintenanceEnds.Store(ends.UnixNano())
+	maintenanceMode.Store(true)
+	return ends
+}
+
+func stopMaintenance() {
+	maintenanceMode.Store(false)
+}
+
+// maintenanceRetryAfter is the Retry-After for a request at now, in whole
+// seconds rounded up.
+func maintenanceRetryAfter(now time.Time) int {
+	left := time.Unix(0, maintenanceEnds.Load()).Sub(now)
+	if left <= 0 {
+		left = maintenanceOverrunRetry
+	}
+	return max(int(math.Ceil(left.Seconds())), 1)
+}
+
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceMode.Load() || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retryAfter := maintenanceRetryAfter(time.Now())
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+				"error":               "down for maintenance",
+				"retry_after_seconds": retryAfter,
+			})
+			return
+		}
+		renderMessagePage(w, http.StatusServiceUnavailable, messagePageData{
+			Title:       "Down for maintenance",
+			Message:     "We'll be back shortly. Please try again in a few minutes.",
+			ArabicTitle: "الموقع قيد الصيانة", ArabicMessage: "سنعود قريبًا. يرجى المحاولة مرة أخرى بعد بضع دقائق.",
+		})
+	})
+}
+
+func maintenanceExempt(path string) bool {
+	return path == "/status" || path == "/health" || path == "/metrics" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/static/")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRetryAfterFollowsWindow(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	t.Cleanup(stopMaintenance)
+
+	if _, err := s.runControlCommand(controlRequest{Command: "maintenance", Args: []string{"on", "90s"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := do(t, ts, http.MethodGet, "/subscribe", nil, "Accept", "application/json")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("during maintenance GET /subscribe = %d, want 503", resp.StatusCode)
+	}
+	header, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || header < 85 || header > 90 {
+		t.Errorf("Retry-After = %q, want the ~90s left in the window", resp.Header.Get("Retry-After"))
+	}
+	var out struct {
+		RetryAfterSeconds int `json:"retry_after_seconds"`
+	}
+	json.Unmarshal([]byte(body), &out)
+	if out.RetryAfterSeconds != header {
+		t.Errorf("retry_after_seconds = %d, header %d", out.RetryAfterSeconds, header)
+	}
+
+	// Pages get the header too; admin endpoints keep working
+	if resp, _ := do(t, ts, http.MethodGet, "/", nil); resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("GET / = %d with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp, _ := do(t, ts, http.MethodGet, "/admin/email-queue", nil, "Authorization", "Bearer "+testAdminToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("admin endpoint during maintenance = %d, want 200", resp.StatusCode)
+	}
+
+	s.runControlCommand(controlRequest{Command: "maintenance", Args: []string{"off"}})
+	if resp, _ := do(t, ts, http.MethodGet, "/subscribe", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("after maintenance GET /subscribe = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceRetryAfter(t *testing.T) {
+	t.Cleanup(stopMaintenance)
+	now := startMaintenance(10 * time.Minute).Add(-10 * time.Minute)
+
+	if got := maintenanceRetryAfter(now); got != 600 {
+		t.Errorf("at the start of a 10m window = %d, want 600", got)
+	}
+	if got := maintenanceRetryAfter(now.Add(9*time.Minute + 59500*time.Millisecond)); got != 1 {
+		t.Errorf("half a second before the end = %d, want 1", got)
+	}
+	if got := maintenanceRetryAfter(now.Add(time.Hour)); got != int(maintenanceOverrunRetry.Seconds()) {
+		t.Errorf("past the end = %d, want %s", got, maintenanceOverrunRetry)
+	}
+}
+
+func TestMaintenanceCommandArgs(t *testing.T) {
+	s := &Server{}
+	t.Cleanup(stopMaintenance)
+	for _, args := range [][]string{nil, {"on", "forever"}, {"on", "-5m"}, {"on", "48h"}, {"off", "5m"}, {"maybe"}} {
+		if _, err := s.runControlCommand(controlRequest{Command: "maintenance", Args: args}); err == nil {
+			t.Errorf("maintenance %q was accepted", args)
+		}
+	}
+	if maintenanceMode.Load() {
+		t.Error("a refused command turned maintenance on")
+	}
+}
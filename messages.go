@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMessageListLimit = 100
+	maxMessageListLimit     = 500
+)
+
+type listedMessage struct {
+	ID                 int64   `json:"id"`
+	Email              string  `json:"email,omitempty"`
+	Message            string  `json:"message"`
+	Language           string  `json:"language,omitempty"`
+	LanguageConfidence float64 `json:"language_confidence"`
+	CreatedAt          string  `json:"created_at"`
+}
+
+// handleListMessages serves GET /messages?language=ar&limit=N, newest first.
+// Email is only known for messages from subscribers. Plain text puts one
+// message per line with newlines escaped; Accept: application/json gets
+// {"messages": [...]}.
+func (s *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultMessageListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.writeError(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxMessageListLimit)
+	}
+
+	out, err := s.store.ListMessages(r.Context(), messageFilter{Language: q.Get("language"), Limit: limit})
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "Failed to fetch messages: "+err.Error())
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]any{"messages": out})
+		return
+	}
+	escape := strings.NewReplacer("\\", `\\`, "\n", `\n`, "\t", `\t`)
+	for _, m := range out {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.ID, m.CreatedAt, m.Language, m.Email, escape.Replace(m.Message))
+	}
+}
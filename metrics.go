@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GET /metrics (admin only) in the Prometheus text format, without the
+// client library. HTTP requests are labelled with the route pattern that
+// served them, not the raw path, so addresses in /api/v1/subscribers/{email}
+// and probes for random URLs don't each become a series; anything the mux
+// didn't route is path="unmatched".
+
+var (
+	subscriptionsTotal   atomic.Uint64 // signups sent a confirmation email
+	emailSendErrorsTotal atomic.Uint64 // failed SMTP sends, retries included
+)
+
+// Prometheus' default buckets, in seconds
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestSeries struct{ method, path, status string }
+
+type durationSeries struct{ method, path string }
+
+type histogram struct {
+	buckets []uint64 // per bucket, not cumulative; the last is +Inf
+	sum     float64
+	count   uint64
+}
+
+var httpMetrics = struct {
+	sync.Mutex
+	requests  map[requestSeries]uint64
+	durations map[durationSeries]*histogram
+}{
+	requests:  map[requestSeries]uint64{},
+	durations: map[durationSeries]*histogram{},
+}
+
+// requestRouteKey holds a *string the mux wrapper fills with the pattern
+// that matched; RequestLogger reads it once the request is done.
+type requestRouteKey struct{}
+
+// recordRoute wraps the mux. ServeMux sets r.Pattern on the request it is
+// handed, which the middleware in between has copied, so the pattern is
+// passed back through the context.
+func recordRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(requestRouteKey{}).(*string); ok {
+			*route = r.Pattern
+		}
+	})
+}
+
+// routeLabel turns "GET /api/v1/subscribers/{email}" into its path part.
+func routeLabel(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	return pattern
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func observeRequest(method, pattern string, status int, elapsed time.Duration) {
+	method, path := methodLabel(method), routeLabel(pattern)
+	seconds := elapsed.Seconds()
+
+	httpMetrics.Lock()
+	defer httpMetrics.Unlock()
+	httpMetrics.requests[requestSeries{method, path, strconv.Itoa(status)}]++
+	h := httpMetrics.durations[durationSeries{method, path}]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(requestDurationBuckets)+1)}
+		httpMetrics.durations[durationSeries{method, path}] = h
+	}
+	h.buckets[sort.SearchFloat64s(requestDurationBuckets, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	verified := true
+	active, err := s.store.Count(r.Context(), subscriberFilter{Verified: &verified})
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Failed to count subscribers: "+err.Error())
+		return
+	}
+
+	var b strings.Builder
+	metricHeader(&b, "myidyarabic_subscriptions_total", "counter", "Signups sent a confirmation email since the server started.")
+	fmt.Fprintf(&b, "myidyarabic_subscriptions_total %d\n", subscriptionsTotal.Load())
+	metricHeader(&b, "myidyarabic_email_send_errors_total", "counter", "Failed SMTP sends since the server started, retries included.")
+	fmt.Fprintf(&b, "myidyarabic_email_send_errors_total %d\n", emailSendErrorsTotal.Load())
+	metricHeader(&b, "myidyarabic_active_subscribers", "gauge", "Verified subscribers who have not unsubscribed.")
+	fmt.Fprintf(&b, "myidyarabic_active_subscribers %d\n", active)
+
+	httpMetrics.Lock()
+	requests := make([]requestSeries, 0, len(httpMetrics.requests))
+	for k := range httpMetrics.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, c := requests[i], requests[j]
+		if a.path != c.path {
+			return a.path < c.path
+		}
+		if a.method != c.method {
+			return a.method < c.method
+		}
+		return a.status < c.status
+	})
+	metricHeader(&b, "myidyarabic_http_requests_total", "counter", "HTTP requests by method, route and status.")
+	for _, k := range requests {
+		fmt.Fprintf(&b, "myidyarabic_http_requests_total{method=%q,path=\"%s\",status=%q} %d\n",
+			k.method, labelEscaper.Replace(k.path), k.status, httpMetrics.requests[k])
+	}
+
+	durations := make([]durationSeries, 0, len(httpMetrics.durations))
+	for k := range httpMetrics.durations {
+		durations = append(durations, k)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		if durations[i].path != durations[j].path {
+			return durations[i].path < durations[j].path
+		}
+		return durations[i].method < durations[j].method
+	})
+	metricHeader(&b, "myidyarabic_http_request_duration_seconds", "histogram", "HTTP request latency by method and route.")
+	for _, k := range durations {
+		h := httpMetrics.durations[k]
+		labels := fmt.Sprintf("method=%q,path=\"%s\"", k.method, labelEscaper.Replace(k.path))
+		var cumulative uint64
+		for i, n := range h.buckets {
+			cumulative += n
+			le := "+Inf"
+			if i < len(requestDurationBuckets) {
+				le = strconv.FormatFloat(requestDurationBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(&b, "myidyarabic_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, le, cumulative)
+		}
+		fmt.Fprintf(&b, "myidyarabic_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(&b, "myidyarabic_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+	httpMetrics.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+)
+
+// Schema changes are numbered migrations, kept here in Go source so the
+// binary carries its own schema. migrations[i] is version i+1; applied
+// versions are recorded in the migrations table with a SHA-256 of their
+// SQL. Never edit a migration that has shipped, not even its whitespace:
+// the server refuses to start on a checksum mismatch. Append a new one
+// instead.
+//
+// Migrations 1-3 are the schema that createTables used to build on every
+// start. They keep IF NOT EXISTS so a database created before versioning
+// adopts them without errors.
+var migrations = []string{
+	// 1: subscribers and contact messages
+	`CREATE TABLE IF NOT EXISTS subscribers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		verified BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		verified_at DATETIME,
+		verification_token TEXT,
+		verification_expires_at DATETIME,
+		unsubscribed_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_subscribers_verification_token ON subscribers(verification_token);
+	CREATE INDEX IF NOT EXISTS idx_subscribers_unsubscribed_at ON subscribers(unsubscribed_at);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscriber_id INTEGER,
+		message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		language TEXT,
+		language_confidence REAL,
+		FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+	);`,
+
+	// 2: funnel, auto-replies and security events
+	`CREATE TABLE IF NOT EXISTS funnel_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscriber_id INTEGER NOT NULL,
+		stage TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_funnel_events_subscriber ON funnel_events(subscriber_id, stage);
+	CREATE INDEX IF NOT EXISTS idx_funnel_events_stage_time ON funnel_events(stage, created_at);
+
+	-- Form views have no subscriber yet, so a per-day counter is enough
+	CREATE TABLE IF NOT EXISTS form_views (
+		day DATE PRIMARY KEY,
+		count INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS auto_replies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		email TEXT NOT NULL,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (message_id) REFERENCES messages(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_auto_replies_email ON auto_replies(email, sent_at);
+
+	CREATE TABLE IF NOT EXISTS security_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		detail TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_security_events_ip ON security_events(ip, created_at);`,
+
+	// 3: accounts and outgoing mail
+	`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider TEXT NOT NULL,
+		provider_user_id TEXT NOT NULL,
+		name TEXT,
+		email TEXT,
+		avatar_url TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, provider_user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS oauth_accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscriber_id INTEGER,
+		provider TEXT NOT NULL,
+		provider_user_id TEXT NOT NULL,
+		name TEXT,
+		avatar_url TEXT,
+		access_token TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, provider_user_id),
+		FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS pending_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		subscriber_id INTEGER,
+		recipient TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		html_body TEXT,
+		headers TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		sent_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_pending_emails_due ON pending_emails(status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS broadcasts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		recipient_count INTEGER NOT NULL,
+		sent_count INTEGER NOT NULL DEFAULT 0,
+		failed_count INTEGER NOT NULL DEFAULT 0,
+		completed_at DATETIME
+	);`,
+
+	// 4: addresses are stored trimmed and lowercased from now on. Rows that
+	// would collide with another row once normalized are left alone rather
+	// than merged.
+	`UPDATE subscribers SET email = lower(trim(email))
+	WHERE email != lower(trim(email))
+		AND NOT EXISTS (SELECT 1 FROM subscribers o
+			WHERE o.id != subscribers.id AND lower(trim(o.email)) = lower(trim(subscribers.email)));`,
+
+	// 5: giveaway draws. A row is written once at commit and once at the
+	// draw; after that the triggers refuse any change.
+	`CREATE TABLE giveaway_draws (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		commitment TEXT NOT NULL,
+		seed TEXT NOT NULL,
+		seed_pinned BOOLEAN NOT NULL DEFAULT 0,
+		committed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		committed_by TEXT NOT NULL,
+		filters TEXT,
+		winner_count INTEGER,
+		candidate_count INTEGER,
+		candidate_digest TEXT,
+		candidate_ids TEXT,
+		winner_ids TEXT,
+		drawn_at DATETIME,
+		drawn_by TEXT
+	);
+	CREATE TRIGGER giveaway_draws_no_update BEFORE UPDATE ON giveaway_draws
+	WHEN OLD.drawn_at IS NOT NULL
+	BEGIN
+		SELECT RAISE(ABORT, 'giveaway draws are immutable once drawn');
+	END;
+	CREATE TRIGGER giveaway_draws_no_delete BEFORE DELETE ON giveaway_draws
+	BEGIN
+		SELECT RAISE(ABORT, 'giveaway draws cannot be deleted');
+	END;`,
+
+	// 6: corrections suggested after a hard bounce to a typo'd provider
+	// domain, kept once accepted or dismissed
+	`ALTER TABLE subscribers ADD COLUMN suggested_email TEXT;
+	ALTER TABLE subscribers ADD COLUMN suggestion_resolved_at DATETIME;`,
+
+	// 7: subscribers linked to the account that logged in with their
+	// address. Foreign keys aren't enforced, so account deletion unlinks
+	// explicitly; a changed address drops the link in the trigger.
+	`ALTER TABLE subscribers ADD COLUMN user_id INTEGER REFERENCES users(id);
+	CREATE INDEX idx_subscribers_user ON subscribers(user_id);
+	CREATE TRIGGER subscribers_email_unlink AFTER UPDATE OF email ON subscribers
+	WHEN NEW.email IS NOT OLD.email AND NEW.user_id IS NOT NULL
+	BEGIN
+		UPDATE subscribers SET user_id = NULL WHERE id = NEW.id;
+	END;`,
+
+	// 8: background export jobs; file is set once the export is done
+	`CREATE TABLE jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		params TEXT NOT NULL,
+		format TEXT NOT NULL,
+		status TEXT NOT NULL,
+		rows_done INTEGER NOT NULL DEFAULT 0,
+		rows_total INTEGER NOT NULL,
+		error TEXT,
+		file TEXT,
+		created_by TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	);
+	CREATE INDEX idx_jobs_status ON jobs(status, finished_at);`,
+
+	// 9: giveaway terms (filters, winner count, candidates) are recorded
+	// with the commitment and can't change afterwards
+	`ALTER TABLE giveaway_draws ADD COLUMN terms_digest TEXT;
+	CREATE TRIGGER giveaway_draws_terms_fixed
+	BEFORE UPDATE OF commitment, seed, seed_pinned, filters, winner_count, candidate_count,
+		candidate_digest, candidate_ids, terms_digest ON giveaway_draws
+	WHEN OLD.terms_digest IS NOT NULL
+	BEGIN
+		SELECT RAISE(ABORT, 'giveaway terms are fixed once committed');
+	END;`,
+
+	// 10: sends logged by MAIL_TRANSPORT=simulate. Staging databases may
+	// already have the table, which startup used to create itself.
+	`CREATE TABLE IF NOT EXISTS simulated_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipient TEXT NOT NULL,
+		subject TEXT,
+		size_bytes INTEGER,
+		outcome TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+
+	// 11: oauth_accounts without subscriber_id. A login never creates or
+	// claims a subscriber there; the link lives on subscribers.user_id. A
+	// column with a foreign key can't be dropped, so the table is rebuilt.
+	`CREATE TABLE oauth_accounts_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider TEXT NOT NULL,
+		provider_user_id TEXT NOT NULL,
+		name TEXT,
+		avatar_url TEXT,
+		access_token TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, provider_user_id)
+	);
+	INSERT INTO oauth_accounts_new(id, provider, provider_user_id, name, avatar_url, access_token, created_at)
+		SELECT id, provider, provider_user_id, name, avatar_url, access_token, created_at FROM oauth_accounts;
+	DROP TABLE oauth_accounts;
+	ALTER TABLE oauth_accounts_new RENAME TO oauth_accounts;`,
+}
+
+// runMigrations applies every migration newer than the database, all in
+// one transaction: either the schema reaches the latest version or nothing
+// changes.
+func runMigrations(db *sql.DB) {
+	if db == nil {
+		log.Fatal("❌ DB is not initialized")
+	}
+
+	if !tableExists(db, "migrations") && tableExists(db, "subscribers") {
+		upgradeLegacySchema(db)
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at DATETIME,
+		checksum TEXT
+	);`)
+	if err != nil {
+		log.Fatalf("❌ Failed to create migrations table: %v", err)
+	}
+	addColumnIfMissing(db, "migrations", "checksum", "TEXT")
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM migrations").Scan(&current); err != nil {
+		log.Fatalf("❌ Failed to read schema version: %v", err)
+	}
+	if current > len(migrations) {
+		log.Fatalf("❌ Database schema is at version %d but this build only knows %d; refusing to run an older binary", current, len(migrations))
+	}
+	verifyMigrationChecksums(db)
+	if current == len(migrations) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("❌ Failed to start migration: %v", err)
+	}
+	for v := current + 1; v <= len(migrations); v++ {
+		if _, err := tx.Exec(migrations[v-1]); err != nil {
+			tx.Rollback()
+			log.Fatalf("❌ Migration %d failed, nothing was applied: %v", v, err)
+		}
+		if _, err := tx.Exec("INSERT INTO migrations(version, applied_at, checksum) VALUES(?, CURRENT_TIMESTAMP, ?)", v, migrationChecksum(v)); err != nil {
+			tx.Rollback()
+			log.Fatalf("❌ Failed to record migration %d, nothing was applied: %v", v, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("❌ Failed to commit migrations: %v", err)
+	}
+	log.Printf("✅ Database schema migrated from version %d to %d", current, len(migrations))
+}
+
+func migrationChecksum(version int) string {
+	sum := sha256.Sum256([]byte(migrations[version-1]))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyMigrationChecksums refuses to start when an applied migration's
+// SQL has changed since it ran: the database no longer matches what this
+// build thinks it contains. Versions applied before checksums were recorded
+// adopt the current ones.
+func verifyMigrationChecksums(db *sql.DB) {
+	rows, err := db.Query("SELECT version, COALESCE(checksum, '') FROM migrations ORDER BY version")
+	if err != nil {
+		log.Fatalf("❌ Failed to read applied migrations: %v", err)
+	}
+	var missing []int
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			log.Fatalf("❌ Failed to read applied migrations: %v", err)
+		}
+		if v < 1 || v > len(migrations) {
+			continue
+		}
+		if sum == "" {
+			missing = append(missing, v)
+		} else if sum != migrationChecksum(v) {
+			log.Fatalf("❌ Migration %d has changed since it was applied (checksum %s, now %s); restore it and add a new migration instead", v, sum, migrationChecksum(v))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("❌ Failed to read applied migrations: %v", err)
+	}
+	rows.Close()
+
+	for _, v := range missing {
+		if _, err := db.Exec("UPDATE migrations SET checksum = ? WHERE version = ?", migrationChecksum(v), v); err != nil {
+			log.Fatalf("❌ Failed to record checksum of migration %d: %v", v, err)
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("✅ Recorded checksums for %d previously applied migrations", len(missing))
+	}
+}
+
+// upgradeLegacySchema brings a database from before versioned migrations up
+// to the shape of migrations 1-3, which then apply as no-ops.
+func upgradeLegacySchema(db *sql.DB) {
+	// Older databases were created before subscribers had a created_at column
+	addColumnIfMissing(db, "subscribers", "created_at", "DATETIME")
+	_, err := db.Exec("UPDATE subscribers SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL")
+	if err != nil {
+		log.Fatalf("❌ Failed to backfill subscribers.created_at: %v", err)
+	}
+	addColumnIfMissing(db, "subscribers", "verified_at", "DATETIME")
+	addColumnIfMissing(db, "subscribers", "verification_token", "TEXT")
+	addColumnIfMissing(db, "subscribers", "verification_expires_at", "DATETIME")
+	addColumnIfMissing(db, "subscribers", "unsubscribed_at", "DATETIME")
+	if tableExists(db, "messages") {
+		addColumnIfMissing(db, "messages", "language", "TEXT")
+		addColumnIfMissing(db, "messages", "language_confidence", "REAL")
+	}
+	if tableExists(db, "pending_emails") {
+		addColumnIfMissing(db, "pending_emails", "html_body", "TEXT")
+	}
+}
+
+func tableExists(db *sql.DB, name string) bool {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	if err != nil {
+		log.Fatalf("❌ Failed to inspect database schema: %v", err)
+	}
+	return n > 0
+}
+
+// addColumnIfMissing adds a column to an existing table. SQLite's ALTER TABLE
+// cannot use non-constant defaults, so callers backfill values themselves.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		log.Fatalf("❌ Failed to inspect %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Fatalf("❌ Failed to inspect %s table: %v", table, err)
+		}
+		if name == column {
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("❌ Failed to inspect %s table: %v", table, err)
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.Fatalf("❌ Failed to add %s.%s: %v", table, column, err)
+	}
+	log.Printf("✅ Added column %s.%s", table, column)
+}
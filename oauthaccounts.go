@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+
+	"github.com/markbates/goth"
+)
+
+// Provider accounts, one row per provider login. The link between a login
+// and a subscriber lives only on subscribers.user_id, set by linkSubscriber
+// from an address the provider vouches for. Access tokens are sealed with AES-256-GCM under a key
+// derived from SESSION_SECRET, so a copy of the database alone doesn't hand
+// out provider API access.
+
+// linkOAuthAccount records the provider account's profile and sealed access
+// token. It creates no subscriber: a login never subscribes anyone.
+func linkOAuthAccount(ctx context.Context, tx *sql.Tx, u goth.User) (int64, error) {
+	sealed, err := sealAccessToken(u.AccessToken)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO oauth_accounts(provider, provider_user_id, name, avatar_url, access_token)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(provider, provider_user_id) DO UPDATE SET
+			name = excluded.name,
+			avatar_url = excluded.avatar_url,
+			access_token = excluded.access_token
+		RETURNING id`,
+		u.Provider, u.UserID, u.Name, u.AvatarURL, sealed).Scan(&id)
+	return id, err
+}
+
+func accessTokenAEAD() (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, tokenSecret, nil, "oauth access token", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAccessToken returns base64(nonce || ciphertext), or "" for no token.
+func sealAccessToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	aead, err := accessTokenAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return b64.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil)), nil
+}
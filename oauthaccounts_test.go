@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func googleUser(email string, verified bool) goth.User {
+	return goth.User{
+		Provider: "google", UserID: "1234", Name: "Amira", Email: email,
+		AccessToken: "ya29.secret-access-token",
+		RawData:     map[string]any{"verified_email": verified},
+	}
+}
+
+func linkedUser(t *testing.T, s *Server, email string) sql.NullInt64 {
+	t.Helper()
+	var userID sql.NullInt64
+	if err := s.db.QueryRow("SELECT user_id FROM subscribers WHERE email = ?", email).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+func TestLoginCreatesNoSubscriber(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+
+	if _, err := s.recordLogin(context.Background(), googleUser("reader@example.com", true)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s.db, "subscribers"); n != 0 {
+		t.Errorf("a login created %d subscriber rows, want 0", n)
+	}
+}
+
+func TestOAuthAccountsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "news.db")
+	db := openDB(path)
+	t.Cleanup(func() { db.Close() })
+
+	// Put the database back at version 10, with an account linked the old way
+	for _, q := range []string{
+		"DROP TABLE oauth_accounts",
+		migrations[2],
+		"DELETE FROM migrations WHERE version = 11",
+		"INSERT INTO oauth_accounts(id, subscriber_id, provider, provider_user_id, name, access_token) VALUES(7, 3, 'google', '1234', 'Amira', 'sealed')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	runMigrations(db)
+
+	if _, err := db.Exec("SELECT subscriber_id FROM oauth_accounts"); err == nil {
+		t.Error("oauth_accounts still has subscriber_id")
+	}
+	var id int64
+	var name, token string
+	if err := db.QueryRow("SELECT id, name, access_token FROM oauth_accounts WHERE provider = 'google' AND provider_user_id = '1234'").Scan(&id, &name, &token); err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 || name != "Amira" || token != "sealed" {
+		t.Errorf("migrated row = %d %q %q", id, name, token)
+	}
+	if _, err := db.Exec("INSERT INTO oauth_accounts(provider, provider_user_id) VALUES('google', '1234')"); err == nil {
+		t.Error("the migrated table lost UNIQUE (provider, provider_user_id)")
+	}
+}
+
+func TestLoginLinksOnlyThroughVerifiedEmail(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	ctx := context.Background()
+	for _, email := range []string{"reader@example.com", "other@example.com"} {
+		if _, err := s.store.AddSubscriber(ctx, email, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Google doesn't vouch for the address: no link
+	userID, err := s.recordLogin(ctx, googleUser("Reader@Example.com", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := linkedUser(t, s, "reader@example.com"); got.Valid {
+		t.Errorf("unverified login email linked user %d", got.Int64)
+	}
+
+	// Now it does, and the address is matched normalized
+	if _, err := s.recordLogin(ctx, googleUser("Reader@Example.com", true)); err != nil {
+		t.Fatal(err)
+	}
+	if got := linkedUser(t, s, "reader@example.com"); got.Int64 != userID {
+		t.Errorf("verified login linked %v, want user %d", got, userID)
+	}
+
+	// The provider later reports another verified address: the link moves
+	if _, err := s.recordLogin(ctx, googleUser("other@example.com", true)); err != nil {
+		t.Fatal(err)
+	}
+	if got := linkedUser(t, s, "reader@example.com"); got.Valid {
+		t.Error("the old address kept its link")
+	}
+	if got := linkedUser(t, s, "other@example.com"); got.Int64 != userID {
+		t.Errorf("new address linked %v, want user %d", got, userID)
+	}
+
+	// Facebook never vouches for addresses
+	fb := goth.User{Provider: "facebook", UserID: "99", Email: "fb@example.com"}
+	s.store.AddSubscriber(ctx, "fb@example.com", nil)
+	if _, err := s.recordLogin(ctx, fb); err != nil {
+		t.Fatal(err)
+	}
+	if got := linkedUser(t, s, "fb@example.com"); got.Valid {
+		t.Error("a Facebook login linked a subscriber")
+	}
+}
+
+func TestLoginStoresOneSealedAccount(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	ctx := context.Background()
+
+	u := googleUser("reader@example.com", true)
+	s.recordLogin(ctx, u)
+	u.Name, u.AccessToken = "Amira K.", "ya29.newer-token"
+	s.recordLogin(ctx, u)
+
+	if n := countRows(t, s.db, "oauth_accounts"); n != 1 {
+		t.Fatalf("%d oauth_accounts rows after two logins, want 1", n)
+	}
+	var name, sealed string
+	if err := s.db.QueryRow("SELECT name, access_token FROM oauth_accounts").Scan(&name, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Amira K." {
+		t.Errorf("name = %q, want the newest profile", name)
+	}
+	if strings.Contains(sealed, "ya29") {
+		t.Fatalf("access token stored in the clear: %q", sealed)
+	}
+
+	raw, err := b64.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := accessTokenAEAD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil || string(plain) != "ya29.newer-token" {
+		t.Errorf("sealed token opens to %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Small bilingual result pages for links people open from their inbox.
+
+type messagePageData struct {
+	Title   string
+	Message string
+	// Optional Arabic rendering shown under the English text
+	ArabicTitle   string
+	ArabicMessage string
+}
+
+var messagePageSample = messagePageData{
+	Title: "Sample", Message: "Sample message.",
+	ArabicTitle: "عنوان", ArabicMessage: "رسالة.",
+}
+
+var messagePage = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>{{.Title}}</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; text-align: center; }
+    section { max-width: 32rem; margin: 1.5rem auto; }
+    a { display: inline-block; margin-top: 1rem; }
+  </style>
+</head>
+<body>
+  <main>
+    <section>
+      <h1>{{.Title}}</h1>
+      <p>{{.Message}}</p>
+    </section>
+    {{if .ArabicTitle}}
+    <section lang="ar" dir="rtl">
+      <h2>{{.ArabicTitle}}</h2>
+      <p>{{.ArabicMessage}}</p>
+    </section>
+    {{end}}
+    <a href="/">Home / الرئيسية</a>
+  </main>
+</body>
+</html>
+`))
+
+func renderMessagePage(w http.ResponseWriter, status int, data messagePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := messagePage.Execute(w, data); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
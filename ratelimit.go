@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Per-IP token buckets. RATE_RPS is the refill rate, RATE_BURST the bucket
+// size and RATE_IDLE_TTL how long an idle client's bucket is kept. Behind a
+// reverse proxy, set TRUSTED_PROXIES so clients are told apart by
+// X-Forwarded-For instead of all sharing the proxy's bucket.
+
+const (
+	defaultRateRPS     = 1
+	defaultRateBurst   = 5
+	defaultRateIdleTTL = 10 * time.Minute
+)
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*rateClient
+	lastSweep time.Time
+
+	rps     rate.Limit
+	burst   int
+	idleTTL time.Duration
+
+	server *Server // for security events
+}
+
+// newRateLimiter builds a limiter with the configured limits. Each limiter
+// keeps its own buckets, so routes wrapped by different limiters don't
+// share a budget.
+func (s *Server) newRateLimiter() *rateLimiter {
+	c := s.cfg
+	return &rateLimiter{
+		server:    s,
+		clients:   make(map[string]*rateClient),
+		lastSweep: time.Now(),
+		rps:       rate.Limit(c.RateRPS),
+		burst:     c.RateBurst,
+		idleTTL:   c.RateIdleTTL,
+	}
+}
+
+// reserve takes a token for ip and returns 0, or how long until one is
+// available without consuming it.
+func (l *rateLimiter) reserve(ip string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Sweep at most once per TTL so the map only holds recently active clients
+	if now.Sub(l.lastSweep) >= l.idleTTL {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) >= l.idleTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// limit wraps a handler with the per-IP limit, answering 429 with a
+// Retry-After of the bucket's actual refill time (whole seconds, rounded
+// up). JSON clients get the same value as retry_after_seconds.
+func (l *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay := l.reserve(remoteHost(r))
+		if delay == 0 {
+			next(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/auth/") {
+			l.server.recordSecurityEvent(r, securityRateLimited, r.URL.Path)
+		}
+		retryAfter := max(int(math.Ceil(delay.Seconds())), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"error":               "too many requests, please slow down",
+				"retry_after_seconds": retryAfter,
+			})
+			return
+		}
+		http.Error(w, "⚠️ Too many requests, please slow down", http.StatusTooManyRequests)
+	}
+}
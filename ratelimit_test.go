@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// At RATE_RPS=0.01 a token takes 100s to refill, so nothing refills while
+// a test runs and every refusal waits out the same full interval.
+var slowRate = map[string]string{"RATE_RPS": "0.01", "RATE_BURST": "5"}
+
+func TestRateLimitParallelSignups(t *testing.T) {
+	_, ts := newTestServer(t, slowRate)
+
+	const n = 40
+	type result struct {
+		status     int
+		retryAfter string
+		body       string
+	}
+	results := make([]result, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, body := do(t, ts, http.MethodPost, "/subscriber/email",
+				map[string]string{"email": fmt.Sprintf("reader%d@example.com", i)})
+			results[i] = result{resp.StatusCode, resp.Header.Get("Retry-After"), body}
+		}()
+	}
+	wg.Wait()
+
+	accepted, limited := 0, 0
+	for _, r := range results {
+		switch r.status {
+		case http.StatusAccepted:
+			accepted++
+		case http.StatusTooManyRequests:
+			limited++
+			var out struct {
+				RetryAfterSeconds int `json:"retry_after_seconds"`
+			}
+			json.Unmarshal([]byte(r.body), &out)
+			if r.retryAfter != "100" || out.RetryAfterSeconds != 100 {
+				t.Errorf("429 with Retry-After %q and retry_after_seconds %d, want 100", r.retryAfter, out.RetryAfterSeconds)
+			}
+		default:
+			t.Errorf("unexpected %d: %s", r.status, r.body)
+		}
+	}
+	if accepted != 5 || limited != n-5 {
+		t.Errorf("%d accepted and %d limited, want 5 and %d", accepted, limited, n-5)
+	}
+	if got := listSubscribers(t, ts); len(got) != 5 {
+		t.Errorf("%d subscribers saved, want the 5 accepted", len(got))
+	}
+}
+
+func TestRateLimitParallelClients(t *testing.T) {
+	s, _ := newTestServer(t, slowRate)
+	l := s.newRateLimiter()
+	served := map[string]*atomic.Int32{}
+	ips := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	for _, ip := range ips {
+		served[ip] = new(atomic.Int32)
+	}
+	h := l.limit(func(w http.ResponseWriter, r *http.Request) {
+		served[remoteHost(r)].Add(1)
+	})
+
+	var limited, badRetryAfter atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 300 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "[" + ips[i%len(ips)] + "]:40000"
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code == http.StatusTooManyRequests {
+				limited.Add(1)
+				if v, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || v != 100 {
+					badRetryAfter.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every client has its own bucket of 5
+	for ip, n := range served {
+		if got := n.Load(); got != 5 {
+			t.Errorf("%s served %d times, want 5", ip, got)
+		}
+	}
+	if got := limited.Load(); got != 300-5*int32(len(ips)) {
+		t.Errorf("%d requests limited, want %d", got, 300-5*len(ips))
+	}
+	if got := badRetryAfter.Load(); got != 0 {
+		t.Errorf("%d 429s without Retry-After: 100", got)
+	}
+}
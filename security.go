@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security-relevant events (forged OAuth state, tampered link tokens, wrong
+// admin tokens, missing CSRF tokens) kept for GET /admin/security. With PRIVACY_LOG=1 only the
+// network part of the client address is stored.
+
+const (
+	securityOAuthFailed     = "oauth_callback_failed"
+	securityInvalidToken    = "invalid_signed_token"
+	securityRateLimited     = "rate_limited"
+	securityAdminAuthFailed = "admin_auth_failed"
+	securityCSRFFailed      = "csrf_failed"
+
+	defaultSecurityAlertThreshold = 20
+	securityAlertWindow           = time.Hour
+)
+
+// securityAlertThreshold is SECURITY_ALERT_THRESHOLD, the number of events
+// from one address within an hour that counts as an anomaly.
+var securityAlertThreshold = defaultSecurityAlertThreshold
+
+// privacyLog is PRIVACY_LOG=1: store only the network part of addresses.
+var privacyLog bool
+
+func initSecurity(c *Config) {
+	securityAlertThreshold, privacyLog, trustedProxies = c.SecurityAlertThreshold, c.PrivacyLog, c.TrustedProxies
+}
+
+// recordSecurityEvent stores an event and logs an alert the moment an
+// address reaches the threshold. Failures are logged, never surfaced.
+func (s *Server) recordSecurityEvent(r *http.Request, kind, detail string) {
+	ip := clientIP(r)
+	if _, err := s.db.ExecContext(r.Context(), "INSERT INTO security_events(kind, ip, detail) VALUES(?, ?, ?)", kind, ip, detail); err != nil {
+		log.Println("⚠️ Failed to record security event:", err)
+		return
+	}
+
+	var recent int
+	err := s.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM security_events WHERE ip = ? AND created_at >= ?",
+		ip, sqliteTime(time.Now().Add(-securityAlertWindow))).Scan(&recent)
+	if err != nil {
+		log.Println("⚠️ Failed to count security events:", err)
+		return
+	}
+	// Equality, not >=, so a sustained burst alerts once rather than per request
+	if recent == securityAlertThreshold {
+		log.Printf("🚨 Security alert: %d suspicious requests from %s in the last hour (latest: %s)", recent, ip, kind)
+	}
+}
+
+// trustedProxies is TRUSTED_PROXIES, comma-separated IPs or CIDRs of the
+// reverse proxies in front of the server. Only their X-Forwarded-For is
+// believed; with none configured the header is ignored, since any client
+// can send it.
+var trustedProxies []netip.Prefix
+
+func isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost is the client address without the port: the peer, or when the
+// peer is a trusted proxy, the nearest X-Forwarded-For hop that isn't one.
+// Hops are read right to left because each proxy appends the address it
+// saw; anything further left was written by the client and can be forged.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A garbled hop: fall back to the last address we can trust
+			break
+		}
+		host = addr.Unmap().String()
+		if !isTrustedProxy(host) {
+			break
+		}
+	}
+	return host
+}
+
+// clientIP returns the peer address for storage, truncated to /24 (IPv4)
+// or /48 (IPv6) when PRIVACY_LOG=1.
+func clientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if !privacyLog {
+		return host
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+type securityGroup struct {
+	IP        string `json:"ip"`
+	Kind      string `json:"kind"`
+	Count     int    `json:"count"`
+	LastHour  int    `json:"last_hour"`
+	LastSeen  string `json:"last_seen"`
+	Anomalous bool   `json:"anomalous"`
+}
+
+type securityReport struct {
+	Since     time.Time       `json:"since"`
+	Threshold int             `json:"threshold"`
+	Groups    []securityGroup `json:"groups"`
+}
+
+// handleSecurity serves GET /admin/security?hours=N (default 24): events
+// grouped by address and kind, busiest first.
+func (s *Server) handleSecurity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "hours must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		hours = n
+	}
+
+	report, err := s.computeSecurityReport(r.Context(), time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		http.Error(w, "❌ Failed to load security events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (s *Server) computeSecurityReport(ctx context.Context, since time.Time) (*securityReport, error) {
+	report := &securityReport{Since: since.UTC().Truncate(time.Second), Threshold: securityAlertThreshold, Groups: []securityGroup{}}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ip, kind, COUNT(*), SUM(created_at >= ?), MAX(created_at)
+		FROM security_events
+		WHERE created_at >= ?
+		GROUP BY ip, kind
+		ORDER BY COUNT(*) DESC, ip`,
+		sqliteTime(time.Now().Add(-securityAlertWindow)), sqliteTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g securityGroup
+		if err := rows.Scan(&g.IP, &g.Kind, &g.Count, &g.LastHour, &g.LastSeen); err != nil {
+			return nil, err
+		}
+		g.Anomalous = g.LastHour >= report.Threshold
+		report.Groups = append(report.Groups, g)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func securityRequest(ip string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = ip + ":40000"
+	return r
+}
+
+// syncBuffer collects log output written from several goroutines.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestSecurityAlertAtThreshold(t *testing.T) {
+	s, _ := newTestServer(t, map[string]string{"SECURITY_ALERT_THRESHOLD": "3"})
+	// Background goroutines log too
+	logged := &syncBuffer{}
+	log.SetOutput(logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Events from before the window don't count toward the alert
+	for range 5 {
+		s.db.Exec("INSERT INTO security_events(kind, ip, created_at) VALUES(?, '192.0.2.1', ?)",
+			securityInvalidToken, sqliteTime(time.Now().Add(-2*time.Hour)))
+	}
+
+	alerts := func() int { return strings.Count(logged.String(), "🚨 Security alert") }
+	for i := 1; i <= 5; i++ {
+		s.recordSecurityEvent(securityRequest("192.0.2.1"), securityInvalidToken, "")
+		want := 0
+		if i >= 3 {
+			want = 1 // once at the threshold, not again for the rest of the burst
+		}
+		if got := alerts(); got != want {
+			t.Errorf("after %d events: %d alerts, want %d", i, got, want)
+		}
+	}
+	if !strings.Contains(logged.String(), "3 suspicious requests from 192.0.2.1") {
+		t.Errorf("alert doesn't name the count and address:\n%s", logged.String())
+	}
+
+	// Another address has its own count
+	s.recordSecurityEvent(securityRequest("192.0.2.2"), securityInvalidToken, "")
+	if got := alerts(); got != 1 {
+		t.Errorf("one event from another address raised an alert")
+	}
+}
+
+func TestComputeSecurityReport(t *testing.T) {
+	s, _ := newTestServer(t, map[string]string{"SECURITY_ALERT_THRESHOLD": "3"})
+	add := func(ip, kind string, ago time.Duration, n int) {
+		for range n {
+			s.db.Exec("INSERT INTO security_events(kind, ip, created_at) VALUES(?, ?, ?)", kind, ip, sqliteTime(time.Now().Add(-ago)))
+		}
+	}
+	add("192.0.2.1", securityInvalidToken, time.Minute, 3)
+	add("192.0.2.1", securityInvalidToken, 5*time.Hour, 2)
+	add("192.0.2.1", securityCSRFFailed, time.Minute, 1)
+	add("192.0.2.2", securityAdminAuthFailed, 3*time.Hour, 4)
+	add("192.0.2.3", securityRateLimited, 48*time.Hour, 9) // before the report
+
+	report, err := s.computeSecurityReport(t.Context(), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Threshold != 3 {
+		t.Errorf("threshold = %d, want 3", report.Threshold)
+	}
+	// Busiest first; the ip breaks ties
+	want := []securityGroup{
+		{IP: "192.0.2.1", Kind: securityInvalidToken, Count: 5, LastHour: 3, Anomalous: true},
+		{IP: "192.0.2.2", Kind: securityAdminAuthFailed, Count: 4, LastHour: 0},
+		{IP: "192.0.2.1", Kind: securityCSRFFailed, Count: 1, LastHour: 1},
+	}
+	if len(report.Groups) != len(want) {
+		t.Fatalf("groups = %+v, want %d", report.Groups, len(want))
+	}
+	for i, g := range report.Groups {
+		if g.LastSeen == "" {
+			t.Errorf("group %d has no last_seen", i)
+		}
+		g.LastSeen = ""
+		if g != want[i] {
+			t.Errorf("group %d = %+v, want %+v", i, g, want[i])
+		}
+	}
+}
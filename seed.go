@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Development-only fake data. Output is fully determined by -seed so
+// screenshots and manual test runs are reproducible.
+
+const seedBatchSize = 5000
+
+var (
+	seedLatinFirst = []string{"ahmed", "mohamed", "yacine", "amine", "karim", "sofiane", "nadia", "amina", "sara", "lina", "yasmine", "meriem", "omar", "youssef", "fatima", "khadija", "samir", "walid", "imane", "rania"}
+	seedLatinLast  = []string{"benali", "bouzid", "haddad", "mansouri", "cherif", "saidi", "belkacem", "brahimi", "toumi", "zeroual", "khelifi", "meziane", "rahmani", "slimani", "ait", "amrani"}
+	seedArabicName = []string{"أحمد", "محمد", "ياسين", "أمين", "كريم", "سفيان", "نادية", "أمينة", "سارة", "لينا", "ياسمين", "مريم", "عمر", "يوسف", "فاطمة", "خديجة"}
+	seedDomains    = []string{"gmail.com", "gmail.com", "gmail.com", "yahoo.com", "yahoo.fr", "hotmail.com", "outlook.com", "live.fr"}
+	seedMessages   = []string{
+		"السلام عليكم، متى يصدر العدد القادم من النشرة؟",
+		"شكراً على المقالات الرائعة، أتمنى المزيد عن تاريخ المنطقة.",
+		"لم تصلني رسالة التأكيد، هل يمكنكم المساعدة؟",
+		"هل يمكن الاشتراك باللغة الفرنسية أيضاً؟",
+		"أرغب في المساهمة بمقال، كيف أتواصل معكم؟",
+		"Bonjour, est-ce que la newsletter existe en français ?",
+		"Hello, I would like to change my email address.",
+		"Great work on the last issue, thank you!",
+		"salam khouya, 3lik tsift li newsletter b l3arbia? ya3tik sahha",
+	}
+)
+
+func (s *Server) runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	subscribers := fs.Int("subscribers", 1000, "number of subscribers to create")
+	messages := fs.Int("messages", 200, "number of contact messages to create")
+	months := fs.Int("months", 6, "spread signup dates over this many months")
+	seed := fs.Uint64("seed", 1, "random seed; the same seed always produces the same data")
+	force := fs.Bool("force", false, "seed even if the database already has data")
+	fs.Parse(args)
+
+	if !*force {
+		var n int
+		err := s.db.QueryRow("SELECT (SELECT COUNT(*) FROM subscribers) + (SELECT COUNT(*) FROM messages)").Scan(&n)
+		if err != nil {
+			log.Fatal("❌ seed: could not inspect database:", err)
+		}
+		if n > 0 {
+			log.Fatal("❌ seed: database is not empty; pass -force to add fake data anyway")
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(*seed, *seed^0x9e3779b97f4a7c15))
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.AddDate(0, -*months, 0)
+
+	began := time.Now()
+	ids, err := s.seedSubscribers(rng, *subscribers, start, now)
+	if err != nil {
+		log.Fatal("❌ seed: subscribers:", err)
+	}
+	if err := s.seedContactMessages(rng, *messages, ids, start, now); err != nil {
+		log.Fatal("❌ seed: messages:", err)
+	}
+	if err := s.seedFormViews(rng, start, now, *subscribers); err != nil {
+		log.Fatal("❌ seed: form views:", err)
+	}
+
+	log.Printf("🌱 Seeded %d subscribers and %d messages in %s", len(ids), *messages, time.Since(began).Round(time.Millisecond))
+}
+
+// batch runs fn over n items in transactions of seedBatchSize rows.
+func (s *Server) batch(n int, fn func(tx *sql.Tx, from, to int) error) error {
+	for from := 0; from < n; from += seedBatchSize {
+		to := min(from+seedBatchSize, n)
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := fn(tx, from, to); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) seedSubscribers(rng *rand.Rand, n int, start, end time.Time) ([]int64, error) {
+	ids := make([]int64, 0, n)
+	span := end.Sub(start)
+
+	err := s.batch(n, func(tx *sql.Tx, from, to int) error {
+		insert, err := tx.Prepare("INSERT OR IGNORE INTO subscribers(email, verified, created_at) VALUES(?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+		event, err := tx.Prepare("INSERT INTO funnel_events(subscriber_id, stage, created_at) VALUES(?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer event.Close()
+
+		for i := from; i < to; i++ {
+			email := fmt.Sprintf("%s.%s%d@%s",
+				pick(rng, seedLatinFirst), pick(rng, seedLatinLast), i, pick(rng, seedDomains))
+			created := start.Add(time.Duration(rng.Int64N(int64(span))))
+
+			// Roughly 70% confirm, a few never receive the email at all
+			delivered := rng.IntN(100) < 95
+			clicked := delivered && rng.IntN(100) < 75
+			verified := clicked && rng.IntN(100) < 97
+
+			res, err := insert.Exec(email, verified, sqliteTime(created))
+			if err != nil {
+				return err
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+
+			stages := []string{stageSubmitted, stageConfirmationSent}
+			if delivered {
+				stages = append(stages, stageDelivered)
+			}
+			if clicked {
+				stages = append(stages, stageLinkClicked)
+			}
+			if verified {
+				stages = append(stages, stageVerified)
+			}
+			at := created
+			for _, stage := range stages {
+				if _, err := event.Exec(id, stage, sqliteTime(at)); err != nil {
+					return err
+				}
+				// Clicks sometimes come days after signup
+				at = at.Add(time.Duration(rng.Int64N(int64(72 * time.Hour))))
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (s *Server) seedContactMessages(rng *rand.Rand, n int, subscriberIDs []int64, start, end time.Time) error {
+	span := end.Sub(start)
+	return s.batch(n, func(tx *sql.Tx, from, to int) error {
+		insert, err := tx.Prepare("INSERT INTO messages(subscriber_id, message, language, language_confidence, created_at) VALUES(?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+
+		for i := from; i < to; i++ {
+			var subscriberID sql.NullInt64
+			if len(subscriberIDs) > 0 && rng.IntN(100) < 60 {
+				subscriberID = sql.NullInt64{Int64: subscriberIDs[rng.IntN(len(subscriberIDs))], Valid: true}
+			}
+			text := pick(rng, seedMessages)
+			if strings.ContainsFunc(text, func(r rune) bool { return r >= 0x0600 && r <= 0x06FF }) {
+				text += "\n— " + pick(rng, seedArabicName)
+			}
+			created := start.Add(time.Duration(rng.Int64N(int64(span))))
+			lang, confidence := detectLanguage(text)
+			if _, err := insert.Exec(subscriberID, text, lang, confidence, sqliteTime(created)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Server) seedFormViews(rng *rand.Rand, start, end time.Time, signups int) error {
+	days := int(end.Sub(start).Hours()/24) + 1
+	// About three views per signup, spread over the range
+	perDay := max(1, signups*3/days)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for d := 0; d < days; d++ {
+		day := start.AddDate(0, 0, d).Format(funnelDateLayout)
+		count := perDay/2 + rng.IntN(perDay+1)
+		_, err := tx.Exec(`INSERT INTO form_views(day, count) VALUES(?, ?)
+			ON CONFLICT(day) DO UPDATE SET count = count + excluded.count`, day, count)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func pick(rng *rand.Rand, options []string) string {
+	return options[rng.IntN(len(options))]
+}
+
+// sqliteTime matches the format CURRENT_TIMESTAMP produces.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/facebook"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+)
+
+// Server holds what the handlers share: the database, the subscriber store
+// (store.go), the session store, the SMTP settings and the error logger.
+// Handlers are methods on it and Routes wires them up, so a test can build
+// one on DATABASE_PATH=:memory: and drive it through httptest.
+//
+// The email queue, broadcasts, rate limits, link secrets and runtime
+// settings are still package-level, so there is one Server per process.
+type Server struct {
+	cfg      *Config
+	db       *sql.DB
+	store    SubscriberStore
+	sessions sessions.Store
+	smtp     smtpConfig
+	log      *slog.Logger
+}
+
+// NewServer opens and migrates the database, applies cfg to every
+// subsystem and starts the background workers (email queue, legacy file
+// writer, export jobs, control socket). Like openDB it exits on a broken database.
+func NewServer(cfg *Config) *Server {
+	s := &Server{cfg: cfg, log: errorLog}
+
+	tokenSecret = []byte(cfg.SessionSecret)
+	unsubscribeSecret = []byte(cfg.UnsubscribeSecret)
+
+	// Goth sessions, kept for 30 days
+	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+	store.MaxAge(86400 * 30)
+	store.Options.Path = "/"
+	store.Options.HttpOnly = true
+	store.Options.Secure = false
+	s.sessions = store
+	// gothic keeps its OAuth state in a package variable of its own
+	gothic.Store = store
+
+	// Set up Goth with providers
+	goth.UseProviders(
+		facebook.New(
+			cfg.FacebookKey,
+			cfg.FacebookSecret,
+			"http://localhost:8080/auth/facebook/callback",
+		),
+		google.New(
+			cfg.GoogleKey,
+			cfg.GoogleSecret,
+			"http://localhost:8080/auth/google/callback",
+			"email", "profile",
+		),
+		github.New(
+			cfg.GithubKey,
+			cfg.GithubSecret,
+			"http://localhost:8080/auth/github/callback",
+		),
+	)
+
+	loadSettings()
+	loadEmailTemplates(cfg)
+	checkTemplates()
+
+	s.db = openDB(cfg.DatabasePath)
+	s.store = newSQLiteStore(s.db)
+	s.initSMTP(cfg)
+	s.initMailTransport(cfg)
+	s.startEmailQueue(cfg)
+	initBroadcasts(cfg)
+	initCampaignLinks(cfg)
+	initSecurity(cfg)
+	s.startLegacyFileWriter(cfg)
+	s.startExportJobs(cfg)
+	go s.logDeliverability()
+	initAdmin(cfg)
+	s.startControlSocket(cfg)
+	return s
+}
+
+// Routes returns the HTTP handler for every page and API endpoint.
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	fs := http.FileServer(http.Dir("./static"))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	mux.HandleFunc("/", serveIndex)
+	mux.HandleFunc("/subscribe", s.serveSubscribe)
+	// Separate buckets so contact messages don't eat into the signup budget
+	subscribeLimiter, submitLimiter, authLimiter := s.newRateLimiter(), s.newRateLimiter(), s.newRateLimiter()
+
+	mux.HandleFunc("/subscriber/email", subscribeLimiter.limit(s.handleEmailSubscription))
+	mux.HandleFunc("/verify", s.handleEmailVerification)
+	mux.HandleFunc("/unsubscribe", s.handleUnsubscribe)
+	mux.Handle("/subscribers", s.adminOnly(http.HandlerFunc(s.handleListSubscribers)))
+	mux.Handle("/view-emails", s.adminOnly(http.HandlerFunc(s.handleViewEmails)))
+	mux.Handle("/messages", s.adminOnly(http.HandlerFunc(s.handleListMessages)))
+	mux.Handle("GET /api/v1/subscribers", s.adminOnly(http.HandlerFunc(s.handleAPISubscribers)))
+	mux.Handle("GET /api/v1/subscribers/{email}", s.adminOnly(http.HandlerFunc(s.handleAPISubscriber)))
+	mux.HandleFunc("/submit", submitLimiter.limit(s.handleFormSubmission))
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.Handle("GET /metrics", s.adminOnly(http.HandlerFunc(s.handleMetrics)))
+	mux.Handle("/admin/deliverability", s.adminOnly(http.HandlerFunc(s.handleDeliverability)))
+	mux.Handle("/admin/funnel", s.adminOnly(http.HandlerFunc(s.handleFunnel)))
+	mux.Handle("/admin/security", s.adminOnly(http.HandlerFunc(s.handleSecurity)))
+	mux.Handle("/admin/export/diff", s.adminOnly(http.HandlerFunc(s.handleExportDiff)))
+	mux.Handle("GET /admin/jobs/{id}", s.adminOnly(http.HandlerFunc(s.handleJobStatus)))
+	mux.Handle("POST /admin/jobs/{id}/cancel", s.adminOnly(http.HandlerFunc(s.handleCancelJob)))
+	mux.HandleFunc("GET /admin/jobs/{id}/download", s.handleJobDownload)
+	mux.Handle("/admin/simulation", s.adminOnly(http.HandlerFunc(s.handleSimulation)))
+	mux.Handle("/admin/email-queue", s.adminOnly(http.HandlerFunc(s.handleEmailQueue)))
+	mux.Handle("GET /admin/errors", s.adminOnly(http.HandlerFunc(handleErrors)))
+	mux.Handle("GET /admin/corrections", s.adminOnly(http.HandlerFunc(s.handleCorrections)))
+	mux.Handle("POST /admin/corrections/{id}/accept", s.adminOnly(http.HandlerFunc(s.handleAcceptCorrection)))
+	mux.Handle("POST /admin/corrections/{id}/dismiss", s.adminOnly(http.HandlerFunc(s.handleDismissCorrection)))
+	mux.Handle("POST /admin/broadcast", s.adminOnly(http.HandlerFunc(s.handleBroadcast)))
+	mux.Handle("GET /admin/broadcast/{id}", s.adminOnly(http.HandlerFunc(s.handleBroadcastStatus)))
+	mux.Handle("POST /admin/giveaway/commit", s.adminOnly(http.HandlerFunc(s.handleGiveawayCommit)))
+	mux.Handle("POST /admin/giveaway/draw", s.adminOnly(http.HandlerFunc(s.handleGiveawayDraw)))
+	mux.Handle("GET /admin/giveaway/{id}", s.adminOnly(http.HandlerFunc(s.handleGiveawayStatus)))
+	mux.HandleFunc("GET /giveaway/{id}", s.handlePublicGiveaway)
+	mux.Handle("GET /admin/email-templates/{name}/raw", s.adminOnly(http.HandlerFunc(s.handleRawEmailPreview)))
+
+	mux.HandleFunc("/me", s.handleMe)
+	mux.HandleFunc("GET /account", s.requireLogin(s.handleAccount))
+	mux.HandleFunc("POST /account/delete", s.requireLogin(s.handleDeleteAccount))
+	mux.HandleFunc("/login", serveLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
+	mux.HandleFunc("/auth/facebook", authLimiter.limit(handleOAuthLogin("facebook")))
+	mux.HandleFunc("/auth/facebook/callback", authLimiter.limit(s.handleOAuthCallback("facebook")))
+	mux.HandleFunc("/auth/google", authLimiter.limit(handleOAuthLogin("google")))
+	mux.HandleFunc("/auth/google/callback", authLimiter.limit(s.handleOAuthCallback("google")))
+	mux.HandleFunc("/auth/github", authLimiter.limit(handleOAuthLogin("github")))
+	mux.HandleFunc("/auth/github/callback", authLimiter.limit(s.handleOAuthCallback("github")))
+
+	return withRequestID(RequestLogger(withTimeout(s.cfg.RequestTimeout, withMaintenance(s.csrfProtect(recordRoute(mux))))))
+}
+
+// Shutdown runs after the HTTP server has drained: running broadcasts
+// finish, export jobs stop, sends in progress finish, and the database
+// closes last, none of them waiting past ctx. Anything still queued stays
+// in the table for the next start.
+func (s *Server) Shutdown(ctx context.Context) {
+	waitForBroadcasts(ctx)
+	stopExportJobs(ctx)
+	log.Printf("🛑 Waiting for %d queued or in-flight emails", len(emailQueue.slots))
+	stopEmailQueue(ctx)
+	log.Println("🛑 Closing the database")
+	s.db.Close()
+}
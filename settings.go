@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// runtimeSettings holds the settings that can change on SIGHUP without a
+// restart. A snapshot is immutable: reloads swap in a new pointer, so code
+// that already called currentSettings keeps a consistent view.
+type runtimeSettings struct {
+	EmailAddress     string
+	EmailPassword    string
+	DKIMDomain       string
+	AutoReplyEnabled bool
+}
+
+var settingsPtr atomic.Pointer[runtimeSettings]
+
+// Settings that are read once at startup; changing them needs a restart.
+var restartRequiredKeys = []string{
+	"SESSION_SECRET", "CSRF_SECRET", "ADMIN_TOKEN", "DATABASE_PATH", "DATABASE_URL",
+	"FACEBOOK_KEY", "FACEBOOK_SECRET",
+	"GOOGLE_KEY", "GOOGLE_SECRET",
+	"GITHUB_KEY", "GITHUB_SECRET",
+	"LEGACY_EMAIL_FILE", "LEGACY_EMAIL_FILE_MAX_BYTES",
+	"MAIL_TRANSPORT", "SIMULATE_LATENCY", "SIMULATE_FAILURE_RATE",
+	"BROADCAST_WORKERS", "BASE_URL", "SIGN_SITE_LINKS", "EMAIL_BLACKLIST_DOMAINS",
+	"SMTP_HOST", "SMTP_PORT", "SMTP_TLS", "SMTP_AUTH", "SMTP_FROM", "SITE_NAME",
+	"CONTROL_SOCKET", "EMAIL_WORKERS", "EMAIL_RETRY_MAX",
+	"RATE_RPS", "RATE_BURST", "RATE_IDLE_TTL", "TRUSTED_PROXIES",
+	"SHUTDOWN_TIMEOUT", "REQUEST_TIMEOUT", "LOG_FORMAT", "EXPORT_DIR", "HEALTH_CHECK_SMTP",
+}
+
+var reloadableKeys = []string{
+	"EMAIL_ADDRESS", "EMAIL_PASSWORD", "DKIM_DOMAIN", "AUTO_REPLY_ENABLED",
+}
+
+func currentSettings() *runtimeSettings {
+	if s := settingsPtr.Load(); s != nil {
+		return s
+	}
+	return settingsFromEnv(os.Getenv)
+}
+
+func settingsFromEnv(getenv func(string) string) *runtimeSettings {
+	return &runtimeSettings{
+		EmailAddress:     getenv("EMAIL_ADDRESS"),
+		EmailPassword:    getenv("EMAIL_PASSWORD"),
+		DKIMDomain:       getenv("DKIM_DOMAIN"),
+		AutoReplyEnabled: getenv("AUTO_REPLY_ENABLED") == "1",
+	}
+}
+
+func loadSettings() {
+	settingsPtr.Store(settingsFromEnv(os.Getenv))
+}
+
+// watchSettingsReload re-reads .env on SIGHUP. Values in the file win over
+// the current environment for the keys it defines.
+func watchSettingsReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadSettings()
+		}
+	}()
+}
+
+func reloadSettings() {
+	fileEnv, err := godotenv.Read()
+	if err != nil {
+		log.Println("⚠️ Reload: could not read .env, keeping current settings:", err)
+		return
+	}
+
+	changed := func(keys []string) []string {
+		var out []string
+		for _, k := range keys {
+			if v, ok := fileEnv[k]; ok && v != os.Getenv(k) {
+				out = append(out, k)
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	applied := changed(reloadableKeys)
+	pending := changed(restartRequiredKeys)
+
+	for _, k := range applied {
+		os.Setenv(k, fileEnv[k])
+	}
+	settingsPtr.Store(settingsFromEnv(os.Getenv))
+	if len(applied) > 0 {
+		invalidateDeliverability()
+	}
+
+	if len(applied) == 0 && len(pending) == 0 {
+		log.Println("🔄 Reload: no settings changed")
+	}
+	for _, k := range applied {
+		log.Println("🔄 Reload: applied", k)
+	}
+	for _, k := range pending {
+		log.Println("⚠️ Reload:", k, "changed but requires a restart")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// MAIL_TRANSPORT=simulate replaces the SMTP call with a fake one for
+// staging: everything up to the send runs for real, then the message is
+// logged to simulated_emails instead of leaving the box. SIMULATE_LATENCY
+// (default 200ms) and SIMULATE_FAILURE_RATE (0-1, default 0) shape the fake
+// send. Read once at startup so production can't flip into simulation on a
+// reload.
+
+const (
+	transportSMTP     = "smtp"
+	transportSimulate = "simulate"
+
+	defaultSimulateLatency = 200 * time.Millisecond
+)
+
+var simulation struct {
+	enabled     bool
+	latency     time.Duration
+	failureRate float64
+}
+
+var errSimulatedFailure = errors.New("simulated send failure")
+
+func (s *Server) initMailTransport(c *Config) {
+	if c.MailTransport != transportSimulate {
+		return
+	}
+	simulation.enabled = true
+	simulation.latency = c.SimulateLatency
+	simulation.failureRate = c.SimulateFailureRate
+
+	log.Printf("🧪 MAIL_TRANSPORT=simulate: no email will leave this server (latency %s, failure rate %.2f)",
+		simulation.latency, simulation.failureRate)
+}
+
+// simulateSend stands in for smtpDeliver.
+func (s *Server) simulateSend(to, subject string, msg []byte) error {
+	time.Sleep(simulation.latency)
+
+	outcome, err := "sent", error(nil)
+	if rand.Float64() < simulation.failureRate {
+		outcome, err = "failed", errSimulatedFailure
+	}
+	_, dbErr := s.db.Exec("INSERT INTO simulated_emails(recipient, subject, size_bytes, outcome) VALUES(?, ?, ?, ?)",
+		to, subject, len(msg), outcome)
+	if dbErr != nil {
+		log.Println("⚠️ Failed to log simulated email:", dbErr)
+	}
+	return err
+}
+
+type simulatedEmail struct {
+	ID        int64  `json:"id"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	SizeBytes int    `json:"size_bytes"`
+	Outcome   string `json:"outcome"`
+	CreatedAt string `json:"created_at"`
+}
+
+var simulatedEmailSample = []simulatedEmail{{
+	ID: 1, Recipient: previewRecipient, Subject: "Sample", SizeBytes: 1024,
+	Outcome: "sent", CreatedAt: "2025-01-01 00:00:00",
+}}
+
+var simulationPage = template.Must(template.New("simulation").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Simulated emails</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; }
+    .staging { background: #9a6700; color: #fff; padding: 0.5rem 1rem; font-weight: bold; }
+    table { border-collapse: collapse; width: 100%; margin-top: 1rem; }
+    td, th { border-bottom: 1px solid #ddd; padding: 0.25rem 0.5rem; text-align: left; }
+    .failed { color: #b42318; }
+  </style>
+</head>
+<body>
+  <div class="staging" role="status">STAGING: simulated mail transport, nothing is really sent</div>
+  <h1>Simulated emails</h1>
+  <table>
+    <tr><th>Time</th><th>Recipient</th><th>Subject</th><th>Size</th><th>Outcome</th></tr>
+    {{range .}}<tr><td>{{.CreatedAt}}</td><td>{{.Recipient}}</td><td>{{.Subject}}</td><td>{{.SizeBytes}}</td><td class="{{.Outcome}}">{{.Outcome}}</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// handleSimulation serves GET /admin/simulation: the latest simulated sends,
+// as HTML or JSON for Accept: application/json.
+func (s *Server) handleSimulation(w http.ResponseWriter, r *http.Request) {
+	if !simulation.enabled {
+		http.Error(w, "Mail simulation is off (MAIL_TRANSPORT is not simulate)", http.StatusNotFound)
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `SELECT id, recipient, COALESCE(subject, ''), size_bytes, outcome, created_at
+		FROM simulated_emails ORDER BY id DESC LIMIT 200`)
+	if err != nil {
+		http.Error(w, "❌ Failed to load simulated emails: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	emails := []simulatedEmail{}
+	for rows.Next() {
+		var e simulatedEmail
+		if err := rows.Scan(&e.ID, &e.Recipient, &e.Subject, &e.SizeBytes, &e.Outcome, &e.CreatedAt); err != nil {
+			http.Error(w, "❌ Failed to read simulated emails: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		emails = append(emails, e)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(emails)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := simulationPage.Execute(w, emails); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSimulationLogsSends(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	subscribe(t, ts, "reader@example.com")
+
+	var emails []simulatedEmail
+	for deadline := time.Now().Add(5 * time.Second); len(emails) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the confirmation never reached simulated_emails")
+		}
+		time.Sleep(10 * time.Millisecond)
+		resp, body := do(t, ts, http.MethodGet, "/admin/simulation", nil,
+			"Authorization", "Bearer "+testAdminToken, "Accept", "application/json")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /admin/simulation = %d %q", resp.StatusCode, body)
+		}
+		if err := json.Unmarshal([]byte(body), &emails); err != nil {
+			t.Fatalf("body %q: %v", body, err)
+		}
+	}
+	if e := emails[0]; e.Recipient != "reader@example.com" || e.Outcome != "sent" || e.SizeBytes == 0 {
+		t.Errorf("simulated send = %+v", e)
+	}
+}
+
+func TestSimulatedEmailsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "news.db")
+	db := openDB(path)
+	t.Cleanup(func() { db.Close() })
+
+	// A staging database from before migration 10 already has the table
+	if _, err := db.Exec("DELETE FROM migrations WHERE version = 10"); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO simulated_emails(recipient, outcome) VALUES('old@example.com', 'sent')")
+	runMigrations(db)
+
+	var version int
+	db.QueryRow("SELECT MAX(version) FROM migrations").Scan(&version)
+	if version != len(migrations) {
+		t.Errorf("schema at version %d, want %d", version, len(migrations))
+	}
+	if n := countRows(t, db, "simulated_emails"); n != 1 {
+		t.Errorf("%d simulated_emails rows after migrating, want the old one kept", n)
+	}
+}
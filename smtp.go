@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP delivery settings, read once at startup (see Config):
+//
+//	SMTP_HOST  server name (default smtp.gmail.com)
+//	SMTP_PORT  default 25, 587 or 465 depending on SMTP_TLS
+//	SMTP_TLS   none | starttls (default) | implicit
+//	SMTP_AUTH  plain (default) | cram-md5 | none
+//	SMTP_FROM  From header, e.g. "MyIdy <news@example.com>"; defaults to
+//	           EMAIL_ADDRESS, which is also the login
+//
+// net/smtp refuses PLAIN over an unencrypted connection except to
+// localhost, so SMTP_TLS=none is only useful for a local relay.
+
+const (
+	smtpTLSNone     = "none"
+	smtpTLSStart    = "starttls"
+	smtpTLSImplicit = "implicit"
+
+	smtpAuthPlain = "plain"
+	smtpAuthCRAM  = "cram-md5"
+	smtpAuthNone  = "none"
+
+	smtpDialTimeout = 30 * time.Second
+)
+
+// smtpSessionTimeout caps one whole SMTP session after the dial, so a
+// server that accepts the connection and then stops answering can't hold
+// a queue worker (and shutdown) forever. A var so tests can shorten it.
+var smtpSessionTimeout = 2 * time.Minute
+
+type smtpConfig struct {
+	host string
+	port int
+	tls  string
+	auth string
+	from *mail.Address // nil: use EMAIL_ADDRESS
+}
+
+// hardBounceError is a permanent (5xx) refusal of the recipient: retrying
+// won't help, and the address may be a typo (see corrections.go).
+type hardBounceError struct {
+	err *textproto.Error
+}
+
+func (e *hardBounceError) Error() string { return "smtp: recipient rejected: " + e.err.Error() }
+func (e *hardBounceError) Unwrap() error { return e.err }
+
+func (s *Server) initSMTP(c *Config) {
+	s.smtp = smtpConfig{host: c.SMTPHost, port: c.SMTPPort, tls: c.SMTPTLS, auth: c.SMTPAuth, from: c.SMTPFrom}
+
+	// Credentials can be fixed with a reload, so this only warns
+	settings := currentSettings()
+	if c.MailTransport == transportSMTP && (settings.EmailAddress == "" || (settings.EmailPassword == "" && s.smtp.auth != smtpAuthNone)) {
+		log.Println("⚠️ EMAIL_ADDRESS or EMAIL_PASSWORD is not set; sending email will fail until they are")
+	}
+}
+
+// senderAddress is the envelope sender and the address in From.
+func (s *Server) senderAddress(cfg *runtimeSettings) string {
+	if s.smtp.from != nil {
+		return s.smtp.from.Address
+	}
+	return cfg.EmailAddress
+}
+
+// fromHeader is the From header value, display name included.
+func (s *Server) fromHeader(cfg *runtimeSettings) string {
+	if s.smtp.from != nil {
+		return s.smtp.from.String()
+	}
+	return cfg.EmailAddress
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain.
+func newMessageID(sender string) string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	domain := "localhost"
+	if at := strings.LastIndexByte(sender, '@'); at >= 0 && at < len(sender)-1 {
+		domain = sender[at+1:]
+	}
+	return "<" + hex.EncodeToString(raw) + "@" + domain + ">"
+}
+
+// smtpDeliver hands one message to the configured server.
+func (s *Server) smtpDeliver(from, to, username, password string, msg []byte) error {
+	addr := net.JoinHostPort(s.smtp.host, strconv.Itoa(s.smtp.port))
+	tlsConfig := &tls.Config{ServerName: s.smtp.host}
+
+	var conn net.Conn
+	var err error
+	if s.smtp.tls == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpSessionTimeout))
+
+	c, err := smtp.NewClient(conn, s.smtp.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.smtp.tls == smtpTLSStart {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not offer STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if s.smtp.auth != smtpAuthNone {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server does not offer AUTH")
+		}
+		var auth smtp.Auth
+		if s.smtp.auth == smtpAuthCRAM {
+			auth = smtp.CRAMMD5Auth(username, password)
+		} else {
+			auth = smtp.PlainAuth("", username, password, s.smtp.host)
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		var tpErr *textproto.Error
+		if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+			return &hardBounceError{tpErr}
+		}
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("smtp: message rejected: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a one-connection SMTP server that records what the client
+// sends. reply overrides the answer to a command, keyed by its verb
+// ("RCPT", or "." for the end of DATA); noAuth leaves AUTH out of EHLO.
+type fakeSMTP struct {
+	reply  map[string]string
+	noAuth bool
+
+	commands []string // each command line, without CRLF
+	data     []byte   // the DATA section as sent, dot-stuffed, up to the final "."
+	done     chan struct{}
+}
+
+func startFakeSMTP(t *testing.T, f *fakeSMTP) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f.done = make(chan struct{})
+
+	go func() {
+		defer close(f.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		f.serve(bufio.NewReader(conn), conn)
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	return &Server{smtp: smtpConfig{host: "127.0.0.1", port: port, tls: smtpTLSNone, auth: smtpAuthPlain}}
+}
+
+func (f *fakeSMTP) serve(r *bufio.Reader, w net.Conn) {
+	write := func(s string) { w.Write([]byte(s + "\r\n")) }
+	write("220 fake.example ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSuffix(line, "\r\n")
+		f.commands = append(f.commands, cmd)
+		verb, _, _ := strings.Cut(cmd, " ")
+		if reply, ok := f.reply[verb]; ok {
+			write(reply)
+			continue
+		}
+		switch verb {
+		case "EHLO":
+			ext := "250-fake.example\r\n250-8BITMIME\r\n"
+			if !f.noAuth {
+				ext += "250-AUTH PLAIN\r\n"
+			}
+			w.Write([]byte(ext + "250 SIZE 1000000\r\n"))
+		case "AUTH":
+			write("235 2.7.0 Authentication successful")
+		case "MAIL", "RCPT":
+			write("250 OK")
+		case "DATA":
+			write("354 End data with <CR><LF>.<CR><LF>")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				f.data = append(f.data, l...)
+			}
+			if reply, ok := f.reply["."]; ok {
+				write(reply)
+			} else {
+				write("250 2.0.0 queued")
+			}
+		case "QUIT":
+			write("221 Bye")
+			return
+		default:
+			write("502 unknown command")
+		}
+	}
+}
+
+func TestSMTPDeliverWireFormat(t *testing.T) {
+	f := &fakeSMTP{}
+	s := startFakeSMTP(t, f)
+	msg := buildMessage("News <news@example.com>", "reader@example.com", "Today", "Hello\n.hidden line\nمرحباً", "",
+		previewTime, previewMessageID, nil)
+
+	if err := s.smtpDeliver("news@example.com", "reader@example.com", "news@example.com", "app-password", msg); err != nil {
+		t.Fatal(err)
+	}
+	<-f.done
+
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00news@example.com\x00app-password"))
+	want := []string{
+		"EHLO localhost",
+		"AUTH PLAIN " + auth,
+		"MAIL FROM:<news@example.com> BODY=8BITMIME",
+		"RCPT TO:<reader@example.com>",
+		"DATA",
+		"QUIT",
+	}
+	if strings.Join(f.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(f.commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	// On the wire every line ends in CRLF and a leading dot is doubled
+	if bytes.Contains(bytes.ReplaceAll(f.data, []byte("\r\n"), nil), []byte("\n")) || !bytes.HasSuffix(f.data, []byte("\r\n")) {
+		t.Errorf("DATA has a bare LF or no final CRLF: %q", f.data)
+	}
+	if !bytes.Contains(f.data, []byte("\r\n..hidden line\r\n")) {
+		t.Errorf("the line starting with a dot wasn't dot-stuffed: %q", f.data)
+	}
+	if got := bytes.ReplaceAll(f.data, []byte("\r\n.."), []byte("\r\n.")); !bytes.Equal(got, msg) {
+		t.Errorf("DATA unstuffed:\n%q\nwant the message:\n%q", got, msg)
+	}
+}
+
+func TestSMTPDeliverRecipientRefused(t *testing.T) {
+	for reply, hard := range map[string]bool{
+		"550 5.1.1 No such user":            true,
+		"450 4.2.1 Mailbox busy, try later": false,
+	} {
+		f := &fakeSMTP{reply: map[string]string{"RCPT": reply}}
+		s := startFakeSMTP(t, f)
+		err := s.smtpDeliver("news@example.com", "reader@example.com", "news@example.com", "pw", []byte("Subject: x\r\n\r\nx\r\n"))
+		var bounce *hardBounceError
+		if err == nil || errors.As(err, &bounce) != hard {
+			t.Errorf("RCPT answered %q: err = %v, want hard bounce %v", reply, err, hard)
+		}
+	}
+}
+
+func TestSMTPDeliverMessageRefused(t *testing.T) {
+	f := &fakeSMTP{reply: map[string]string{".": "554 5.7.1 Message looks like spam"}}
+	s := startFakeSMTP(t, f)
+	err := s.smtpDeliver("news@example.com", "reader@example.com", "news@example.com", "pw", []byte("Subject: x\r\n\r\nx\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "message rejected") || !strings.Contains(err.Error(), "spam") {
+		t.Errorf("err = %v, want the server's rejection", err)
+	}
+}
+
+func TestSMTPDeliverAuth(t *testing.T) {
+	// AUTH is required unless SMTP_AUTH=none
+	f := &fakeSMTP{noAuth: true}
+	s := startFakeSMTP(t, f)
+	if err := s.smtpDeliver("news@example.com", "reader@example.com", "news@example.com", "pw", []byte("x\r\n")); err == nil || !strings.Contains(err.Error(), "AUTH") {
+		t.Errorf("server without AUTH: err = %v", err)
+	}
+
+	f = &fakeSMTP{noAuth: true}
+	s = startFakeSMTP(t, f)
+	s.smtp.auth = smtpAuthNone
+	if err := s.smtpDeliver("news@example.com", "reader@example.com", "", "", []byte("x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-f.done
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "AUTH") {
+			t.Errorf("SMTP_AUTH=none sent %q", c)
+		}
+	}
+
+	// STARTTLS is required when configured
+	f = &fakeSMTP{}
+	s = startFakeSMTP(t, f)
+	s.smtp.tls = smtpTLSStart
+	if err := s.smtpDeliver("news@example.com", "reader@example.com", "news@example.com", "pw", []byte("x\r\n")); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("server without STARTTLS: err = %v", err)
+	}
+}
+
+func TestSMTPDeliverSilentServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		// Accept, then never send the greeting
+		conn, err := ln.Accept()
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	defer func(d time.Duration) { smtpSessionTimeout = d }(smtpSessionTimeout)
+	smtpSessionTimeout = 200 * time.Millisecond
+	s := &Server{smtp: smtpConfig{host: "127.0.0.1", port: ln.Addr().(*net.TCPAddr).Port, tls: smtpTLSNone, auth: smtpAuthNone}}
+
+	started := time.Now()
+	err = s.smtpDeliver("news@example.com", "reader@example.com", "", "", []byte("x\r\n"))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("smtpDeliver took %s against a silent server", elapsed)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Public service status: coarse component states only, never counts or
+// error text, so it is safe to show to subscribers and embedding sites.
+
+const (
+	stateOperational = "operational"
+	stateDegraded    = "degraded"
+	stateDown        = "down"
+)
+
+const (
+	sendOutcomeWindow = time.Hour
+	maxSendOutcomes   = 200
+)
+
+// sendOutcomes remembers recent SMTP results to derive the email state.
+var sendOutcomes struct {
+	sync.Mutex
+	at []time.Time
+	ok []bool
+}
+
+func recordSendOutcome(ok bool) {
+	sendOutcomes.Lock()
+	defer sendOutcomes.Unlock()
+	sendOutcomes.at = append(sendOutcomes.at, time.Now())
+	sendOutcomes.ok = append(sendOutcomes.ok, ok)
+	if n := len(sendOutcomes.at); n > maxSendOutcomes {
+		sendOutcomes.at = sendOutcomes.at[n-maxSendOutcomes:]
+		sendOutcomes.ok = sendOutcomes.ok[n-maxSendOutcomes:]
+	}
+}
+
+// emailState buckets the failure rate over the last hour.
+func emailState() string {
+	sendOutcomes.Lock()
+	defer sendOutcomes.Unlock()
+
+	cutoff := time.Now().Add(-sendOutcomeWindow)
+	total, failed := 0, 0
+	for i, at := range sendOutcomes.at {
+		if at.Before(cutoff) {
+			continue
+		}
+		total++
+		if !sendOutcomes.ok[i] {
+			failed++
+		}
+	}
+	switch {
+	case total == 0 || failed*10 < total:
+		return stateOperational
+	case failed*2 < total:
+		return stateDegraded
+	}
+	return stateDown
+}
+
+func (s *Server) databaseState(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		log.Println("⚠️ Status: database ping failed:", err)
+		return stateDown
+	}
+	return stateOperational
+}
+
+type componentStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type serviceStatus struct {
+	State      string            `json:"state"`
+	Components []componentStatus `json:"components"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+var serviceStatusSample = serviceStatus{
+	State:      stateDegraded,
+	Components: []componentStatus{{Name: "email", State: stateDegraded}},
+	UpdatedAt:  previewTime,
+}
+
+func (s *Server) currentStatus(ctx context.Context) serviceStatus {
+	status := serviceStatus{
+		State: stateOperational,
+		Components: []componentStatus{
+			{Name: "web", State: stateOperational},
+			{Name: "database", State: s.databaseState(ctx)},
+			{Name: "email", State: emailState()},
+		},
+		UpdatedAt: time.Now().UTC(),
+	}
+	for _, c := range status.Components {
+		if c.State == stateDown {
+			status.State = stateDown
+			break
+		}
+		if c.State == stateDegraded {
+			status.State = stateDegraded
+		}
+	}
+	return status
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Service status</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; max-width: 32rem; margin: auto; }
+    li { display: flex; justify-content: space-between; padding: 0.5rem 0; border-bottom: 1px solid #ddd; }
+    .operational { color: #1e7b34; } .degraded { color: #9a6700; } .down { color: #b42318; }
+  </style>
+</head>
+<body>
+  <h1>Service status <span lang="ar" dir="rtl">/ حالة الخدمة</span></h1>
+  <p>Overall: <strong class="{{.State}}">{{.State}}</strong></p>
+  <ul>
+    {{range .Components}}<li><span>{{.Name}}</span><strong class="{{.State}}">{{.State}}</strong></li>
+    {{end}}
+  </ul>
+  <p><small>Updated {{.UpdatedAt.Format "2006-01-02 15:04 MST"}}</small></p>
+</body>
+</html>
+`))
+
+// handleStatus serves GET /status as HTML, or JSON for Accept: application/json.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.currentStatus(r.Context())
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPage.Execute(w, status); err != nil {
+		log.Println("⚠️ Status page render failed:", err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SubscriberStore is what the signup, verification, listing and contact
+// handlers need from the database, so they hold no SQL of their own and a
+// test can hand the Server another implementation. Lookups of a missing row
+// return errNotFound; every other error is wrapped with what was being done.
+// Unsubscribe, broadcasts, giveaways and the rest still query s.db directly.
+// sqliteStore is the only implementation. A Postgres one is deferred: it
+// needs a driver in go.mod, Postgres versions of the migrations and of the
+// queries outside this interface, and the email queue and rate limits moved
+// out of the process before a second instance could run, so until then
+// databasePathFromURL refuses postgres:// URLs.
+type SubscriberStore interface {
+	// AddSubscriber records email as signing up and returns its row. A new
+	// address starts unverified; one that had unsubscribed is made
+	// unverified again, so it has to confirm afresh. confirm, when not nil,
+	// runs in the same transaction; if it fails nothing is kept.
+	AddSubscriber(ctx context.Context, email string, confirm func(q dbtx, sub subscriberRow) error) (subscriberRow, error)
+	GetByEmail(ctx context.Context, email string) (subscriberRow, error)
+	GetByVerificationToken(ctx context.Context, tokenHash string) (subscriberRow, error)
+	// MarkVerified reports whether the row changed; false means it was
+	// already verified.
+	MarkVerified(ctx context.Context, id int) (bool, error)
+	List(ctx context.Context, f subscriberFilter) ([]subscriberRow, error)
+	Count(ctx context.Context, f subscriberFilter) (int, error)
+	// AddMessage stores a contact-form message and its sender in one
+	// transaction. A new sender is saved as an unverified subscriber, which
+	// mails them nothing; an existing row is linked as it is.
+	AddMessage(ctx context.Context, email, message string) (int64, error)
+	ListMessages(ctx context.Context, f messageFilter) ([]listedMessage, error)
+}
+
+var errNotFound = errors.New("not found")
+
+// dbtx is a *sql.DB or a *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type subscriberRow struct {
+	ID           int
+	Email        string
+	Verified     bool
+	Unsubscribed bool
+	CreatedAt    string       // RFC 3339, UTC
+	TokenExpires sql.NullTime // verification link expiry
+}
+
+// tokenExpired reports whether the row's verification link can no longer
+// be used.
+func (sub subscriberRow) tokenExpired(now time.Time) bool {
+	return !sub.TokenExpires.Valid || now.After(sub.TokenExpires.Time)
+}
+
+// subscriberFilter narrows List and Count. The zero value is every
+// still-subscribed address in signup order; Limit 0 means no limit.
+type subscriberFilter struct {
+	Verified            *bool
+	IncludeUnsubscribed bool
+	Limit, Offset       int
+}
+
+type messageFilter struct {
+	Language string // "" for all
+	Limit    int
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(db *sql.DB) *sqliteStore {
+	return &sqliteStore{db: db}
+}
+
+const subscriberColumns = `id, email, verified, unsubscribed_at IS NOT NULL,
+	strftime('%Y-%m-%dT%H:%M:%SZ', created_at), verification_expires_at`
+
+func scanSubscriber(row interface{ Scan(...any) error }) (subscriberRow, error) {
+	var sub subscriberRow
+	err := row.Scan(&sub.ID, &sub.Email, &sub.Verified, &sub.Unsubscribed, &sub.CreatedAt, &sub.TokenExpires)
+	return sub, err
+}
+
+func (st *sqliteStore) getOne(ctx context.Context, what, where string, arg any) (subscriberRow, error) {
+	sub, err := scanSubscriber(st.db.QueryRowContext(ctx, "SELECT "+subscriberColumns+" FROM subscribers WHERE "+where, arg))
+	if err == sql.ErrNoRows {
+		return sub, errNotFound
+	}
+	if err != nil {
+		return sub, fmt.Errorf("look up subscriber by %s: %w", what, err)
+	}
+	return sub, nil
+}
+
+func (st *sqliteStore) GetByEmail(ctx context.Context, email string) (subscriberRow, error) {
+	return st.getOne(ctx, "email", "email = ?", email)
+}
+
+func (st *sqliteStore) GetByVerificationToken(ctx context.Context, tokenHash string) (subscriberRow, error) {
+	return st.getOne(ctx, "token", "verification_token = ?", tokenHash)
+}
+
+func (st *sqliteStore) AddSubscriber(ctx context.Context, email string, confirm func(q dbtx, sub subscriberRow) error) (subscriberRow, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return subscriberRow{}, fmt.Errorf("save subscriber: %w", err)
+	}
+	defer tx.Rollback()
+
+	// SET sees the row as it was, so only an unsubscribed address is reset
+	sub, err := scanSubscriber(tx.QueryRowContext(ctx, `INSERT INTO subscribers(email, created_at) VALUES(?, CURRENT_TIMESTAMP)
+		ON CONFLICT(email) DO UPDATE SET
+			verified = CASE WHEN unsubscribed_at IS NULL THEN verified ELSE 0 END,
+			verified_at = CASE WHEN unsubscribed_at IS NULL THEN verified_at END,
+			unsubscribed_at = NULL
+		RETURNING `+subscriberColumns, email))
+	if err != nil {
+		return sub, fmt.Errorf("save subscriber: %w", err)
+	}
+	if confirm != nil {
+		if err := confirm(tx, sub); err != nil {
+			return sub, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return sub, fmt.Errorf("save subscriber: %w", err)
+	}
+	return sub, nil
+}
+
+func (st *sqliteStore) MarkVerified(ctx context.Context, id int) (bool, error) {
+	res, err := st.db.ExecContext(ctx, "UPDATE subscribers SET verified = 1, verified_at = CURRENT_TIMESTAMP WHERE id = ? AND verified = 0", id)
+	if err != nil {
+		return false, fmt.Errorf("verify subscriber: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("verify subscriber: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (f subscriberFilter) where() (string, []any) {
+	var where []string
+	var args []any
+	if f.Verified != nil {
+		where = append(where, "verified = ?")
+		args = append(args, *f.Verified)
+	}
+	if !f.IncludeUnsubscribed {
+		where = append(where, "unsubscribed_at IS NULL")
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (st *sqliteStore) List(ctx context.Context, f subscriberFilter) ([]subscriberRow, error) {
+	where, args := f.where()
+	query := "SELECT " + subscriberColumns + " FROM subscribers" + where + " ORDER BY id"
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	out := []subscriberRow{}
+	for rows.Next() {
+		sub, err := scanSubscriber(rows)
+		if err != nil {
+			return nil, fmt.Errorf("read subscriber: %w", err)
+		}
+		out = append(out, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list subscribers: %w", err)
+	}
+	return out, nil
+}
+
+func (st *sqliteStore) Count(ctx context.Context, f subscriberFilter) (int, error) {
+	where, args := f.where()
+	var n int
+	if err := st.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscribers"+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count subscribers: %w", err)
+	}
+	return n, nil
+}
+
+func (st *sqliteStore) AddMessage(ctx context.Context, email, message string) (int64, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("save message: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The no-op update makes RETURNING give an existing row's id without
+	// touching its verified or unsubscribed state
+	var subscriberID int
+	err = tx.QueryRowContext(ctx, `INSERT INTO subscribers(email, created_at) VALUES(?, CURRENT_TIMESTAMP)
+		ON CONFLICT(email) DO UPDATE SET email = excluded.email
+		RETURNING id`, email).Scan(&subscriberID)
+	if err != nil {
+		return 0, fmt.Errorf("save sender: %w", err)
+	}
+
+	lang, confidence := detectLanguage(message)
+	var id int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO messages(subscriber_id, message, language, language_confidence)
+		VALUES(?, ?, ?, ?) RETURNING id`, subscriberID, message, lang, confidence).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("save message: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("save message: %w", err)
+	}
+	return id, nil
+}
+
+func (st *sqliteStore) ListMessages(ctx context.Context, f messageFilter) ([]listedMessage, error) {
+	query := `SELECT m.id, s.email, m.message, m.language, m.language_confidence,
+		strftime('%Y-%m-%dT%H:%M:%SZ', m.created_at)
+		FROM messages m LEFT JOIN subscribers s ON s.id = m.subscriber_id`
+	args := []any{}
+	if f.Language != "" {
+		query += " WHERE m.language = ?"
+		args = append(args, f.Language)
+	}
+	args = append(args, f.Limit)
+
+	rows, err := st.db.QueryContext(ctx, query+" ORDER BY m.id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	defer rows.Close()
+
+	out := []listedMessage{}
+	for rows.Next() {
+		var m listedMessage
+		var email, message, lang, created sql.NullString
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&m.ID, &email, &message, &lang, &confidence, &created); err != nil {
+			return nil, fmt.Errorf("read message: %w", err)
+		}
+		m.Email, m.Message, m.Language, m.CreatedAt = email.String, message.String, lang.String, created.String
+		m.LanguageConfidence = confidence.Float64
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	return out, nil
+}
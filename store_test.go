@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// newTestStore opens a migrated in-memory database on its own, for tests
+// of the store that need no Server.
+func newTestStore(t *testing.T) (*sqliteStore, *sql.DB) {
+	t.Helper()
+	db := openDB(":memory:")
+	t.Cleanup(func() { db.Close() })
+	return newSQLiteStore(db), db
+}
+
+// failInserts makes every insert into table abort, standing in for a
+// failure partway through a transaction.
+func failInserts(t *testing.T, db *sql.DB, table string) {
+	t.Helper()
+	_, err := db.Exec(`CREATE TRIGGER fail_` + table + ` BEFORE INSERT ON ` + table + `
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAddMessageSavesSenderAndMessage(t *testing.T) {
+	st, db := newTestStore(t)
+	ctx := context.Background()
+
+	id, err := st.AddMessage(ctx, "reader@example.com", "مرحبا، لدي سؤال عن الدورة")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := st.GetByEmail(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("sender not saved: %v", err)
+	}
+	if sub.Verified || sub.Unsubscribed {
+		t.Errorf("new sender = %+v, want unverified and subscribed", sub)
+	}
+	var linked int
+	if err := db.QueryRow("SELECT subscriber_id FROM messages WHERE id = ?", id).Scan(&linked); err != nil {
+		t.Fatal(err)
+	}
+	if linked != sub.ID {
+		t.Errorf("message linked to subscriber %d, want %d", linked, sub.ID)
+	}
+}
+
+func TestAddMessageLeavesExistingSubscriberAlone(t *testing.T) {
+	st, db := newTestStore(t)
+	ctx := context.Background()
+
+	for _, email := range []string{"verified@example.com", "gone@example.com"} {
+		sub, err := st.AddSubscriber(ctx, email, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.MarkVerified(ctx, sub.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE email = 'gone@example.com'"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"verified@example.com", "gone@example.com"} {
+		before, _ := st.GetByEmail(ctx, email)
+		if _, err := st.AddMessage(ctx, email, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := st.GetByEmail(ctx, email)
+		if after != before {
+			t.Errorf("a message from %s changed its row from %+v to %+v", email, before, after)
+		}
+	}
+	if n := countRows(t, db, "subscribers"); n != 2 {
+		t.Errorf("%d subscriber rows, want 2", n)
+	}
+}
+
+func TestAddMessageRollsBackOnFailure(t *testing.T) {
+	st, db := newTestStore(t)
+	failInserts(t, db, "messages")
+
+	if _, err := st.AddMessage(context.Background(), "reader@example.com", "hello"); err == nil {
+		t.Fatal("AddMessage succeeded with the message insert failing")
+	}
+	if n := countRows(t, db, "subscribers"); n != 0 {
+		t.Errorf("%d subscriber rows left by a failed message, want 0", n)
+	}
+	if n := countRows(t, db, "messages"); n != 0 {
+		t.Errorf("%d messages left by a failed insert, want 0", n)
+	}
+}
+
+func TestAddSubscriberRollsBackWhenConfirmFails(t *testing.T) {
+	st, db := newTestStore(t)
+	injected := errors.New("injected failure")
+
+	_, err := st.AddSubscriber(context.Background(), "reader@example.com", func(q dbtx, sub subscriberRow) error {
+		if _, err := issueVerificationToken(context.Background(), q, sub.ID); err != nil {
+			return err
+		}
+		return injected
+	})
+	if !errors.Is(err, injected) {
+		t.Fatalf("AddSubscriber = %v, want the confirm error", err)
+	}
+	if n := countRows(t, db, "subscribers"); n != 0 {
+		t.Errorf("%d subscriber rows left by a failed confirm, want 0", n)
+	}
+}
+
+func TestAddSubscriberResubscribeStartsUnverified(t *testing.T) {
+	st, db := newTestStore(t)
+	ctx := context.Background()
+
+	sub, _ := st.AddSubscriber(ctx, "reader@example.com", nil)
+	st.MarkVerified(ctx, sub.ID)
+	if again, _ := st.AddSubscriber(ctx, "reader@example.com", nil); !again.Verified {
+		t.Error("signing up again while subscribed dropped verification")
+	}
+
+	db.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = ?", sub.ID)
+	back, err := st.AddSubscriber(ctx, "reader@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.ID != sub.ID || back.Verified || back.Unsubscribed {
+		t.Errorf("resubscribed row = %+v, want the same id, unverified and subscribed", back)
+	}
+}
+
+// Through the handlers: a failure after the first insert answers 500 and
+// leaves nothing behind.
+func TestHandlersRollBackOnFailure(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	failInserts(t, s.db, "pending_emails")
+	failInserts(t, s.db, "messages")
+
+	resp, _ := do(t, ts, http.MethodPost, "/subscriber/email", map[string]string{"email": "reader@example.com"})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("signup with the email insert failing = %d, want 500", resp.StatusCode)
+	}
+	resp, _ = do(t, ts, http.MethodPost, "/submit", map[string]string{"email": "writer@example.com", "message": "hello"})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("message with the insert failing = %d, want 500", resp.StatusCode)
+	}
+
+	if n := countRows(t, s.db, "subscribers"); n != 0 {
+		t.Errorf("%d subscriber rows left behind, want 0", n)
+	}
+	if n := countRows(t, s.db, "pending_emails"); n != 0 {
+		t.Errorf("%d queued emails left behind, want 0", n)
+	}
+	if n := len(emailQueue.slots); n != 0 {
+		t.Errorf("%d email queue slots still taken, want 0", n)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+	// Keeps (page-1)*per_page far from overflowing
+	maxPage = 1 << 20
+)
+
+type apiSubscriber struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"verified"`
+	CreatedAt string `json:"created_at"`
+}
+
+type subscriberPage struct {
+	Data     []apiSubscriber `json:"data"`
+	Total    int             `json:"total"`
+	Page     int             `json:"page"`
+	PerPage  int             `json:"per_page"`
+	NextPage *string         `json:"next_page"`
+	PrevPage *string         `json:"prev_page"`
+}
+
+// pageParam reads a positive integer query parameter. Values out of range
+// are clamped rather than rejected; only non-numbers are an error.
+func pageParam(q url.Values, name string, def, limit int) (int, bool) {
+	v := q.Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return min(max(n, 1), limit), true
+}
+
+// handleAPISubscribers serves GET /api/v1/subscribers?page=1&per_page=50.
+// Unsubscribed addresses are not listed, matching /subscribers.
+func (s *Server) handleAPISubscribers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, ok := pageParam(q, "page", 1, maxPage)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "page must be an integer"})
+		return
+	}
+	perPage, ok := pageParam(q, "per_page", defaultPerPage, maxPerPage)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "per_page must be an integer"})
+		return
+	}
+
+	result := subscriberPage{Data: []apiSubscriber{}, Page: page, PerPage: perPage}
+	var err error
+	if result.Total, err = s.store.Count(r.Context(), subscriberFilter{}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to count subscribers"})
+		return
+	}
+
+	subs, err := s.store.List(r.Context(), subscriberFilter{Limit: perPage, Offset: (page - 1) * perPage})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch subscribers"})
+		return
+	}
+	for _, sub := range subs {
+		result.Data = append(result.Data, apiSubscriber{int64(sub.ID), sub.Email, sub.Verified, sub.CreatedAt})
+	}
+
+	link := func(p int) *string {
+		sub := "/api/v1/subscribers?page=" + strconv.Itoa(p) + "&per_page=" + strconv.Itoa(perPage)
+		return &sub
+	}
+	if page*perPage < result.Total {
+		result.NextPage = link(page + 1)
+	}
+	if page > 1 {
+		// From past the end, "previous" is the last page that has rows
+		last := max((result.Total+perPage-1)/perPage, 1)
+		result.PrevPage = link(min(page-1, last))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+type apiSubscription struct {
+	apiSubscriber
+	Status string `json:"status"` // pending, subscribed or unsubscribed
+}
+
+func subscriptionStatus(verified, unsubscribed bool) string {
+	switch {
+	case unsubscribed:
+		return "unsubscribed"
+	case verified:
+		return "subscribed"
+	}
+	return "pending"
+}
+
+// handleAPISubscriber serves GET /api/v1/subscribers/{email}: one address
+// and where it stands, including after unsubscribing.
+func (s *Server) handleAPISubscriber(w http.ResponseWriter, r *http.Request) {
+	if err := validateEmail(r.PathValue("email")); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "email " + err.Error()})
+		return
+	}
+	email := normalizeEmail(r.PathValue("email"))
+
+	sub, err := s.store.GetByEmail(r.Context(), email)
+	if err == errNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "subscriber not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch subscriber"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiSubscription{
+		apiSubscriber: apiSubscriber{int64(sub.ID), sub.Email, sub.Verified, sub.CreatedAt},
+		Status:        subscriptionStatus(sub.Verified, sub.Unsubscribed),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+)
+
+// Every page and email template is executed once at startup against a
+// fully populated sample of its data, so a misspelled field, a wrong method
+// or a type mismatch stops the boot with the template name and line instead
+// of failing one request or one subscriber's send. Templates fed a map are
+// parsed with missingkey=error, so an unknown key fails too instead of
+// printing "<no value>".
+// Samples live next to the data types; a new template needs an entry in
+// templateChecks. Broadcast bodies get the same check when they are saved
+// (parseCampaignBody).
+
+type executor interface {
+	Name() string
+	Execute(w io.Writer, data any) error
+}
+
+type templateCheck struct {
+	t      executor
+	sample any
+}
+
+// emailCheck renders both halves of an email template pair through
+// renderEmail, which also checks the Subject line.
+type emailCheck string
+
+func (n emailCheck) Name() string { return string(n) }
+
+func (n emailCheck) Execute(_ io.Writer, data any) error {
+	_, _, _, err := renderEmail(string(n), data)
+	return err
+}
+
+func templateChecks() ([]templateCheck, error) {
+	checks := []templateCheck{
+		{statusPage, serviceStatusSample},
+		{simulationPage, simulatedEmailSample},
+		{correctionsPage, correctionSample},
+		{loginPage, loginProviders},
+		{messagePage, messagePageSample},
+		{unsubscribeConfirmPage, unsubscribeConfirmSample},
+		{accountPage, accountSample},
+	}
+	for name, sample := range emailTemplateSamples {
+		checks = append(checks, templateCheck{emailCheck(name), sample})
+	}
+	// Re-read on every request, so this only vouches for the file as it is now
+	page, err := parseSubscribePage()
+	if err != nil {
+		return nil, err
+	}
+	return append(checks, templateCheck{page, subscribePageSample}), nil
+}
+
+// validateTemplates executes each check and reports every failure, not
+// just the first.
+func validateTemplates(checks []templateCheck) error {
+	var errs []error
+	for _, c := range checks {
+		if err := c.t.Execute(io.Discard, c.sample); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkTemplates() {
+	checks, err := templateChecks()
+	if err == nil {
+		err = validateTemplates(checks)
+	}
+	if err != nil {
+		log.Fatalf("❌ Template check failed:\n%v", err)
+	}
+	log.Printf("✅ Checked %d templates against their sample data", len(checks))
+}
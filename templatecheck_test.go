@@ -0,0 +1,37 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestValidateTemplates(t *testing.T) {
+	newTestServer(t, nil) // loads the email templates
+	checks, err := templateChecks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateTemplates(checks); err != nil {
+		t.Errorf("the real templates fail their check:\n%v", err)
+	}
+
+	typo := template.Must(template.New("typo").Parse(`<p>{{.Emial}}</p>`))
+	missing := template.Must(template.New("missing").Option("missingkey=error").Parse(`<p>{{.Emial}}</p>`))
+	err = validateTemplates([]templateCheck{
+		{messagePage, messagePageSample},
+		{typo, messagePageSample},
+		{missing, map[string]string{"Email": "reader@example.com"}},
+	})
+	if err == nil {
+		t.Fatal("a misspelled field passed the check")
+	}
+	for _, name := range []string{`"typo"`, `"missing"`, "Emial"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't mention %s", err, name)
+		}
+	}
+	if strings.Contains(err.Error(), `"message"`) {
+		t.Errorf("error %q blames the valid template", err)
+	}
+}
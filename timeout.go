@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every request's context carries a REQUEST_TIMEOUT deadline (0 turns it
+// off) and the handlers pass it to the database, so a query stuck on a
+// lock, or one whose client has gone, is abandoned instead of holding a
+// goroutine. A handler that then fails with a 500 is answered with a 503
+// saying the request timed out, with a Retry-After of one timeout; the
+// handler's own error text is dropped.
+// The driver can't interrupt a wait for a SQLite lock, so such a query
+// still runs out its busy_timeout (5s) before the deadline is noticed.
+// Work a handler starts in the background (broadcasts, export jobs, auto
+// replies) has its own context and is not cut short.
+
+const defaultRequestTimeout = 10 * time.Second
+
+func withTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	retryAfter := int(math.Ceil(d.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(&timeoutWriter{ResponseWriter: w, ctx: ctx, retryAfter: retryAfter}, r.WithContext(ctx))
+	})
+}
+
+// timeoutWriter turns a 500 written after the deadline into the 503.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx        context.Context
+	retryAfter int // seconds
+	timedOut   bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if code != http.StatusInternalServerError || !errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.timedOut = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Retry-After", strconv.Itoa(w.retryAfter))
+	const msg = "The request took too long and was stopped; please try again"
+	if strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		writeJSON(w.ResponseWriter, http.StatusServiceUnavailable, map[string]any{"error": msg, "retry_after_seconds": w.retryAfter})
+		return
+	}
+	http.Error(w.ResponseWriter, "⚠️ "+msg, http.StatusServiceUnavailable)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
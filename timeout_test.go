@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endlessQuery keeps SQLite busy until the statement is interrupted.
+const endlessQuery = "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT count(*) FROM n"
+
+// slowHandler runs endlessQuery under the request context, reports how it
+// ended on queryErr, and fails the way handlers do.
+func slowHandler(s *Server, queryErr chan<- error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		err := s.db.QueryRowContext(r.Context(), endlessQuery).Scan(&n)
+		queryErr <- err
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Database error")
+	})
+}
+
+func TestTimeoutStopsQuery(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	queryErr := make(chan error, 2)
+	ts := httptest.NewServer(withTimeout(100*time.Millisecond, slowHandler(s, queryErr)))
+	t.Cleanup(ts.Close)
+
+	started := time.Now()
+	resp, body := do(t, ts, http.MethodGet, "/", nil, "Accept", "application/json")
+	if err := <-queryErr; err == nil {
+		t.Fatal("the query outlived the deadline")
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("the query ran %s past a 100ms deadline", elapsed)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	var out struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil || out.RetryAfterSeconds != 1 || !strings.Contains(out.Error, "too long") {
+		t.Errorf("body = %q, want the timeout error and retry_after_seconds", body)
+	}
+
+	// Pages get the same answer as text
+	resp, body = do(t, ts, http.MethodGet, "/", nil)
+	<-queryErr
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" || strings.Contains(body, "Database error") {
+		t.Errorf("page = %d %q with Retry-After %q", resp.StatusCode, body, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestClientGoneStopsQuery(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	queryErr := make(chan error, 1)
+	ts := httptest.NewServer(withTimeout(time.Minute, slowHandler(s, queryErr)))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if resp, err := ts.Client().Do(req); err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.Fatalf("request finished with %d, want the client to give up", resp.StatusCode)
+	}
+
+	select {
+	case err := <-queryErr:
+		if err == nil {
+			t.Error("the query finished instead of being stopped")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the query kept running after the client went away")
+	}
+}
+
+func TestTimeoutLeavesOtherErrorsAlone(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	ts := httptest.NewServer(withTimeout(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.writeError(w, r, http.StatusInternalServerError, "❌ Database error")
+	})))
+	t.Cleanup(ts.Close)
+
+	resp, body := do(t, ts, http.MethodGet, "/", nil)
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Retry-After") != "" || !strings.Contains(body, "Database error") {
+		t.Errorf("a 500 before the deadline = %d %q with Retry-After %q", resp.StatusCode, body, resp.Header.Get("Retry-After"))
+	}
+}
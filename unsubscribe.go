@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Opt-out without logging in. Unsubscribing only stamps unsubscribed_at,
+// so the row and its messages are kept for auditing.
+//
+// Tokens are id.base64(HMAC(id, email)) and never expire: a link in a
+// years-old email must still work. Binding the email means a token dies if
+// the address on the row changes, and without the secret nobody can mint one.
+
+const unsubscribeTokenPurpose = "unsubscribe"
+
+// unsubscribeSecret is UNSUBSCRIBE_SECRET, falling back to SESSION_SECRET.
+var unsubscribeSecret []byte
+
+func unsubscribeToken(subscriberID int, email string) string {
+	id := strconv.Itoa(subscriberID)
+	return id + "." + b64.EncodeToString(unsubscribeMAC(id, email))
+}
+
+func unsubscribeMAC(id, email string) []byte {
+	h := hmac.New(sha256.New, unsubscribeSecret)
+	h.Write([]byte(unsubscribeTokenPurpose + "\x00" + id + "\x00" + strings.ToLower(email)))
+	return h.Sum(nil)
+}
+
+// unsubscribeLink builds the signed opt-out link for a subscriber.
+func unsubscribeLink(subscriberID int, email string) string {
+	return siteLink("/unsubscribe", url.Values{"token": {unsubscribeToken(subscriberID, email)}})
+}
+
+// withUnsubscribe appends the opt-out footer to a message body and adds the
+// RFC 8058 one-click headers. headers is copied, never modified.
+func withUnsubscribe(body string, headers map[string]string, link string) (string, map[string]string) {
+	out := maps.Clone(headers)
+	if out == nil {
+		out = make(map[string]string, 2)
+	}
+	out["List-Unsubscribe"] = "<" + link + ">"
+	out["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	return body + "\n\n--\nUnsubscribe / إلغاء الاشتراك: " + link, out
+}
+
+// withUnsubscribeHTML adds the same footer to an HTML body, inside <body>
+// when there is one.
+func withUnsubscribeHTML(html, link string) string {
+	if html == "" {
+		return ""
+	}
+	esc := template.HTMLEscapeString(link)
+	footer := `<p dir="auto" style="font-size: 12px; color: #666666; text-align: center;">` +
+		`<a href="` + esc + `" style="color: #666666;">Unsubscribe / إلغاء الاشتراك</a></p>` + "\n"
+	if i := strings.LastIndex(html, "</body>"); i >= 0 {
+		return html[:i] + footer + html[i:]
+	}
+	return html + footer
+}
+
+// subscriberUnsubscribeLink returns the opt-out link for an address, or ""
+// when it isn't on the list.
+func (s *Server) subscriberUnsubscribeLink(email string) (string, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM subscribers WHERE email = ?", email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return unsubscribeLink(id, email), nil
+}
+
+// parseUnsubscribeToken checks a token against the subscriber it names.
+func (s *Server) parseUnsubscribeToken(ctx context.Context, token string) (id int, email string, unsubscribed bool, err error) {
+	idPart, macPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", false, errTokenInvalid
+	}
+	if id, err = strconv.Atoi(idPart); err != nil {
+		return 0, "", false, errTokenInvalid
+	}
+	mac, err := b64.DecodeString(macPart)
+	if err != nil {
+		return 0, "", false, errTokenInvalid
+	}
+
+	err = s.db.QueryRowContext(ctx, "SELECT email, unsubscribed_at IS NOT NULL FROM subscribers WHERE id = ?", id).
+		Scan(&email, &unsubscribed)
+	if err == sql.ErrNoRows {
+		return 0, "", false, errTokenInvalid
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	if !hmac.Equal(mac, unsubscribeMAC(idPart, email)) {
+		return 0, "", false, errTokenInvalid
+	}
+	return id, email, unsubscribed, nil
+}
+
+type unsubscribeConfirmData struct {
+	Email string
+	Token string
+	// The address is linked to an account and the visitor isn't logged in
+	LoginHint bool
+}
+
+var unsubscribeConfirmSample = unsubscribeConfirmData{Email: previewRecipient, Token: "sample-unsubscribe-token", LoginHint: true}
+
+var unsubscribeConfirmPage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Unsubscribe</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; text-align: center; }
+    section { max-width: 32rem; margin: 1.5rem auto; }
+    button { margin-top: 1rem; padding: 0.5rem 1.5rem; }
+  </style>
+</head>
+<body>
+  <main>
+    <section>
+      <h1>Unsubscribe</h1>
+      <p>Stop sending emails to {{.Email}}?</p>
+    </section>
+    <section lang="ar" dir="rtl">
+      <h2>إلغاء الاشتراك</h2>
+      <p>هل تريد إيقاف إرسال الرسائل إلى هذا العنوان؟</p>
+    </section>
+    <form method="POST" action="/unsubscribe">
+      <input type="hidden" name="token" value="{{.Token}}" />
+      <button type="submit">Unsubscribe / إلغاء الاشتراك</button>
+    </form>
+    {{if .LoginHint}}<p><a href="/login">Log in to manage your subscription more easily / سجّل الدخول لإدارة اشتراكك بسهولة</a></p>{{end}}
+  </main>
+</body>
+</html>
+`))
+
+// handleUnsubscribe shows a confirmation page on GET and unsubscribes on
+// POST, so link scanners that prefetch URLs can't opt people out.
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := parseLimitedForm(w, r); err != nil {
+			s.writeFormError(w, r, err)
+			return
+		}
+	}
+
+	// One-click clients POST to the link itself, so the token may be in the query
+	token := r.FormValue("token")
+	id, email, unsubscribed, err := s.parseUnsubscribeToken(r.Context(), token)
+	if err == errTokenInvalid {
+		if token != "" {
+			s.recordSecurityEvent(r, securityInvalidToken, "unsubscribe")
+		}
+		renderMessagePage(w, http.StatusBadRequest, messagePageData{
+			Title:       "Invalid link",
+			Message:     "This unsubscribe link is not valid. Please use the link from one of our emails.",
+			ArabicTitle: "رابط غير صالح", ArabicMessage: "رابط إلغاء الاشتراك غير صالح. يرجى استخدام الرابط الموجود في إحدى رسائلنا.",
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "❌ Failed to look up subscriber: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if unsubscribed {
+		renderMessagePage(w, http.StatusOK, messagePageData{
+			Title:       "Already unsubscribed",
+			Message:     email + " is already unsubscribed. You won't receive any more emails from us.",
+			ArabicTitle: "تم إلغاء الاشتراك مسبقاً", ArabicMessage: "تم إلغاء اشتراك هذا العنوان مسبقاً، لن تصلك رسائل أخرى منا.",
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := unsubscribeConfirmData{Email: email, Token: token}
+		if _, loggedIn := s.sessionUserID(r); !loggedIn {
+			// Only a hint, so a failed lookup just leaves it out
+			s.db.QueryRowContext(r.Context(), "SELECT user_id IS NOT NULL FROM subscribers WHERE id = ?", id).Scan(&data.LoginHint)
+		}
+		err := unsubscribeConfirmPage.Execute(w, data)
+		if err != nil {
+			log.Println("⚠️ Page render failed:", err)
+		}
+		return
+	}
+
+	_, err = s.db.ExecContext(r.Context(), "UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = ? AND unsubscribed_at IS NULL", id)
+	if err != nil {
+		http.Error(w, "❌ Failed to unsubscribe: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("👋 Unsubscribed:", email)
+
+	renderMessagePage(w, http.StatusOK, messagePageData{
+		Title:       "You have been unsubscribed",
+		Message:     email + " will no longer receive our emails.",
+		ArabicTitle: "تم إلغاء الاشتراك", ArabicMessage: "لن يصلك أي بريد منا بعد الآن.",
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLinksUseBaseURL(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"BASE_URL": "https://news.example.com/ar/"})
+
+	subscribe(t, ts, "reader@example.com")
+	var body string
+	s.db.QueryRow("SELECT body FROM pending_emails").Scan(&body)
+	if !strings.Contains(body, "https://news.example.com/ar/verify?token=") {
+		t.Errorf("confirmation email doesn't link to BASE_URL: %q", body)
+	}
+	if got := unsubscribeLink(7, "reader@example.com"); !strings.HasPrefix(got, "https://news.example.com/ar/unsubscribe?token=7.") {
+		t.Errorf("unsubscribeLink = %q, want it on BASE_URL", got)
+	}
+}
+
+func TestLinksWithoutBaseURLPointAtLocalServer(t *testing.T) {
+	newTestServer(t, nil)
+
+	if got := verificationLink("a+b/c"); got != "http://localhost:8080/verify?token=a%2Bb%2Fc" {
+		t.Errorf("verificationLink = %q", got)
+	}
+	if got := unsubscribeLink(7, "reader@example.com"); !strings.HasPrefix(got, "http://localhost:8080/unsubscribe?token=7.") {
+		t.Errorf("unsubscribeLink = %q", got)
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	sub, err := s.store.AddSubscriber(t.Context(), "reader@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(unsubscribeLink(sub.ID, sub.Email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	// GET only asks, so a link scanner can't unsubscribe anyone
+	resp, page := do(t, ts, http.MethodGet, link.RequestURI(), nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(page, `name="token"`) {
+		t.Fatalf("GET unsubscribe link = %d %q", resp.StatusCode, page)
+	}
+	if got, _ := s.store.GetByEmail(t.Context(), sub.Email); got.Unsubscribed {
+		t.Fatal("GET unsubscribed the address")
+	}
+
+	// The one-click POST carries no CSRF token
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/unsubscribe",
+		strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusOK {
+		t.Errorf("POST unsubscribe = %d, want 200", post.StatusCode)
+	}
+	if got, _ := s.store.GetByEmail(t.Context(), sub.Email); !got.Unsubscribed {
+		t.Error("POST didn't unsubscribe the address")
+	}
+
+	// A token for one address doesn't work for another
+	forged := strings.Replace(token, "1.", "2.", 1)
+	s.store.AddSubscriber(t.Context(), "other@example.com", nil)
+	if resp, _ := do(t, ts, http.MethodGet, "/unsubscribe?token="+url.QueryEscape(forged), nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("token moved to another id = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+// OAuth users. One row per (provider, provider account): the same email
+// from Google and GitHub stays as two users, because providers don't all
+// verify email ownership and linking on it would let one account take over
+// another.
+
+const (
+	userSessionName = "myidy_session"
+	userSessionKey  = "user_id"
+)
+
+type user struct {
+	ID             int64  `json:"id"`
+	Provider       string `json:"provider"`
+	ProviderUserID string `json:"provider_user_id"`
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	AvatarURL      string `json:"avatar_url"`
+	CreatedAt      string `json:"created_at"`
+	LastLogin      string `json:"last_login"`
+}
+
+// recordLogin stores a successful login in one transaction: the user, the
+// provider account and the link to the subscriber with the same verified
+// address. Repeating it for the same login changes nothing but profile
+// fields and last_login.
+func (s *Server) recordLogin(ctx context.Context, u goth.User) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := upsertUser(ctx, tx, u)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := linkOAuthAccount(ctx, tx, u); err != nil {
+		return 0, err
+	}
+	if err := linkSubscriber(ctx, tx, userID, u); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// upsertUser stores a login: new accounts are inserted, known ones get
+// fresh profile fields and last_login.
+func upsertUser(ctx context.Context, tx *sql.Tx, u goth.User) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO users(provider, provider_user_id, name, email, avatar_url)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(provider, provider_user_id) DO UPDATE SET
+			name = excluded.name,
+			email = excluded.email,
+			avatar_url = excluded.avatar_url,
+			last_login = CURRENT_TIMESTAMP
+		RETURNING id`,
+		u.Provider, u.UserID, u.Name, u.Email, u.AvatarURL).Scan(&id)
+	return id, err
+}
+
+// startUserSession records the user id in the session cookie.
+func (s *Server) startUserSession(w http.ResponseWriter, r *http.Request, userID int64) error {
+	session, _ := s.sessions.Get(r, userSessionName)
+	session.Values[userSessionKey] = userID
+	return session.Save(r, w)
+}
+
+// sessionUserID returns the logged-in user id, if any.
+func (s *Server) sessionUserID(r *http.Request) (int64, bool) {
+	session, err := s.sessions.Get(r, userSessionName)
+	if err != nil {
+		return 0, false
+	}
+	id, ok := session.Values[userSessionKey].(int64)
+	return id, ok
+}
+
+// handleMe serves GET /me: the logged-in user as JSON.
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := s.sessionUserID(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	var u user
+	var name, email, avatar sql.NullString
+	err := s.db.QueryRowContext(r.Context(), `SELECT id, provider, provider_user_id, name, email, avatar_url,
+		strftime('%Y-%m-%dT%H:%M:%SZ', created_at), strftime('%Y-%m-%dT%H:%M:%SZ', last_login)
+		FROM users WHERE id = ?`, id).
+		Scan(&u.ID, &u.Provider, &u.ProviderUserID, &name, &email, &avatar, &u.CreatedAt, &u.LastLogin)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "❌ Failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.Name, u.Email, u.AvatarURL = name.String, email.String, avatar.String
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// requireLogin lets logged-in users through. Others get a 401 JSON error
+// when they asked for JSON, or are sent to the login page.
+func (s *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.sessionUserID(r); ok {
+			next(w, r)
+			return
+		}
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "login required"})
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+// endUserSession clears both the gothic provider session and ours.
+func (s *Server) endUserSession(w http.ResponseWriter, r *http.Request) {
+	if err := gothic.Logout(w, r); err != nil {
+		// Usually an expired or undecodable cookie, which is logged out anyway
+		log.Println("⚠️ gothic logout:", err)
+	}
+	session, _ := s.sessions.Get(r, userSessionName)
+	session.Values = make(map[any]any)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Println("⚠️ Could not clear session cookie:", err)
+	}
+}
+
+// logoutRedirects are the internal paths ?redirect= may send people to
+// after logging out; anything else is ignored, so it can't be used as an
+// open redirect.
+var logoutRedirects = map[string]bool{
+	"/":          true,
+	"/subscribe": true,
+	"/login":     true,
+	"/status":    true,
+}
+
+// handleLogout ends both the gothic provider session and ours. It always
+// succeeds, even without a session, so a logout button never shows an
+// error.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.endUserSession(w, r)
+	if target := r.URL.Query().Get("redirect"); logoutRedirects[target] {
+		http.Redirect(w, r, target, http.StatusSeeOther)
+		return
+	}
+	renderMessagePage(w, http.StatusOK, messagePageData{
+		Title:       "Signed out",
+		Message:     "You have been signed out.",
+		ArabicTitle: "تم تسجيل الخروج", ArabicMessage: "تم تسجيل خروجك بنجاح.",
+	})
+}
+
+// OAuth providers offered on the login page, in order
+var loginProviders = []string{"google", "github", "facebook"}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Log in</title>
+  <style>
+    body { font-family: Arial, sans-serif; padding: 2rem; text-align: center; }
+    section { max-width: 32rem; margin: 1.5rem auto; }
+    a.provider { display: block; margin: 0.75rem auto; max-width: 16rem; padding: 0.5rem; border: 1px solid #ccc; }
+  </style>
+</head>
+<body>
+  <main>
+    <section>
+      <h1>Log in</h1>
+      <p>You need to log in to see this page.</p>
+    </section>
+    <section lang="ar" dir="rtl">
+      <h2>تسجيل الدخول</h2>
+      <p>يجب تسجيل الدخول لعرض هذه الصفحة.</p>
+    </section>
+    {{range .}}<a class="provider" href="/auth/{{.}}">Continue with {{.}}</a>
+    {{end}}
+  </main>
+</body>
+</html>
+`))
+
+func serveLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := loginPage.Execute(w, loginProviders); err != nil {
+		log.Println("⚠️ Page render failed:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// loginClient logs a user in the way the OAuth callback does and returns a
+// browser-like client holding the session cookie. It doesn't follow
+// redirects, so tests can see where they point.
+func loginClient(t *testing.T, s *Server, ts *httptest.Server) *http.Client {
+	t.Helper()
+	userID, err := s.recordLogin(context.Background(), googleUser("reader@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := s.startUserSession(w, httptest.NewRequest(http.MethodGet, "/", nil), userID); err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(ts.URL)
+	jar.SetCookies(u, w.Result().Cookies())
+	return &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func TestLoginSessionCycle(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	get := func(c *http.Client, path string, headers ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Anonymous: browsers go to the login page, API clients get a 401
+	anon := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	if resp := get(anon, "/account"); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Errorf("anonymous GET /account = %d to %q, want 303 to /login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp := get(anon, "/account", "Accept", "application/json"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous JSON GET /account = %d, want 401", resp.StatusCode)
+	}
+
+	c := loginClient(t, s, ts)
+	if resp := get(c, "/account"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("logged-in GET /account = %d, want 200", resp.StatusCode)
+	}
+	if resp := get(c, "/me"); resp.StatusCode != http.StatusOK {
+		t.Errorf("logged-in GET /me = %d, want 200", resp.StatusCode)
+	}
+
+	if resp := get(c, "/logout"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /logout = %d", resp.StatusCode)
+	}
+	u, _ := url.Parse(ts.URL)
+	for _, cookie := range c.Jar.Cookies(u) {
+		if strings.HasPrefix(cookie.Name, userSessionName) {
+			t.Errorf("session cookie %s still set after logout", cookie.Name)
+		}
+	}
+	if resp := get(c, "/account"); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Errorf("GET /account after logout = %d to %q, want 303 to /login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp := get(c, "/me"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /me after logout = %d, want 401", resp.StatusCode)
+	}
+}